@@ -0,0 +1,425 @@
+// Copyright 2018 Eryx <evorui аt gmail dοt com>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgsqlgo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// dialectStmtBind rewrites the "?" placeholders of sql into the $n
+// positional parameters of PostgreSQL. The statement is scanned once by a
+// lexer which skips string literals ('...', E'...', $tag$...$tag$), quoted
+// identifiers and comments, and leaves the jsonb operators ?| and ?& alone.
+// A literal "?", e.g. the jsonb key exists operator, is written as "??".
+//
+// An Expr arg is written in place of its placeholder, its own args are bound
+// in turn. Unless strict, so is a string which looks like a call of a
+// whitelisted function (see dialectStmtBindVarFunc); in strict mode every
+// plain string is bound as a parameter. A statement with $n parameters is
+// taken as already numbered and vars are passed as is, a literal "?" is
+// written as "??" there too, and "?" placeholders are an error. The error
+// reports a
+// mismatch between the number of placeholders and args; the statement is
+// still rewritten then, so that the driver reports the mismatch too.
+func dialectStmtBind(sql string, vars []interface{}, strict bool) (string, []interface{}, error) {
+	b := &stmtBinder{
+		strict: strict,
+	}
+	return b.run(sql, vars)
+}
+
+// run binds the whole statement, see dialectStmtBind.
+func (b *stmtBinder) run(sql string, vars []interface{}) (string, []interface{}, error) {
+
+	b.buf.Grow(len(sql) + 8)
+
+	num := b.bind(sql, vars)
+
+	if b.numbered > 0 {
+
+		if num > 0 {
+			return b.buf.String(), b.args,
+				errors.New("Statement has both ? placeholders and $n parameters")
+		}
+
+		nb := &stmtBinder{
+			literal: true,
+		}
+		nb.buf.Grow(len(sql))
+		nb.bind(sql, nil)
+
+		if nb.numbered != len(vars) {
+			return nb.buf.String(), vars,
+				fmt.Errorf("Statement has %d positional parameters, got %d args", nb.numbered, len(vars))
+		}
+		return nb.buf.String(), vars, nil
+	}
+
+	if num != len(vars) {
+		if num < len(vars) {
+			b.args = append(b.args, vars[num:]...)
+		}
+		return b.buf.String(), b.args,
+			fmt.Errorf("Statement has %d placeholders, got %d args", num, len(vars))
+	}
+
+	return b.buf.String(), b.args, b.err
+}
+
+type stmtBinder struct {
+	strict  bool
+	buf     strings.Builder
+	args    []interface{}
+	missing int
+	err     error
+
+	// the highest $n of an already numbered statement
+	numbered int
+
+	// "?" is not a placeholder, in an already numbered statement
+	literal bool
+
+	// named parameters, see dialectStmtBindNamed
+	named     map[string]interface{}
+	positions map[string]int
+	brackets  int // depth of [], in which "lo:hi" is an array slice
+}
+
+// bind writes sql with its placeholders replaced by vars, and returns the
+// number of placeholders.
+func (b *stmtBinder) bind(sql string, vars []interface{}) int {
+
+	num := 0
+
+	for i := 0; i < len(sql); {
+
+		var (
+			c = sql[i]
+			j = i + 1
+		)
+
+		switch {
+
+		case c == '\'':
+			j = stmtScanString(sql, i, stmtIsEscapeString(sql, i))
+
+		case c == '"':
+			j = stmtScanString(sql, i, false)
+
+		case c == '-' && j < len(sql) && sql[j] == '-':
+			if n := strings.IndexByte(sql[i:], '\n'); n > 0 {
+				j = i + n
+			} else {
+				j = len(sql)
+			}
+
+		case c == '/' && j < len(sql) && sql[j] == '*':
+			j = stmtScanComment(sql, i)
+
+		case c == '$':
+			if tag := stmtDollarTag(sql, i); tag != "" {
+				if n := strings.Index(sql[i+len(tag):], tag); n >= 0 {
+					j = i + len(tag) + n + len(tag)
+				} else {
+					j = len(sql)
+				}
+			} else if n := stmtParamNum(sql, i); n > 0 {
+				if n > b.numbered {
+					b.numbered = n
+				}
+			}
+
+		case c == '[':
+			b.brackets++
+
+		case c == ']' && b.brackets > 0:
+			b.brackets--
+
+		case (c == ':' || c == '@') && b.named != nil:
+
+			// "::type" casts
+			if c == ':' && j < len(sql) && sql[j] == ':' {
+				j++
+				break
+			}
+			// array slices, e.g. arr[lo:hi] or arr[:hi]
+			if c == ':' && b.brackets > 0 && i > 0 && (sql[i-1] == '[' || stmtIsIdentChar(sql[i-1])) {
+				break
+			}
+			if (i > 0 && (sql[i-1] == '<' || sql[i-1] == '@')) || !stmtIsNameStart(sql, j) {
+				break
+			}
+
+			for j < len(sql) && stmtIsIdentChar(sql[j]) && sql[j] != '$' {
+				j++
+			}
+			b.namedValue(sql[i+1 : j])
+			num++
+			i = j
+			continue
+
+		case c == '?' && b.named == nil:
+
+			if j < len(sql) {
+				// "??" is a literal "?"
+				if sql[j] == '?' {
+					b.buf.WriteByte('?')
+					i += 2
+					continue
+				}
+				// the jsonb operators ?| and ?&
+				if (sql[j] == '|' || sql[j] == '&') && (j+1 >= len(sql) || sql[j+1] != sql[j]) {
+					j++
+					break
+				}
+			}
+
+			if b.literal {
+				break
+			}
+
+			if num < len(vars) {
+				b.value(vars[num])
+			} else {
+				// keep numbering, so that the driver reports the missing args
+				b.missing++
+				b.buf.WriteString("$" + strconv.Itoa(len(b.args)+b.missing))
+			}
+			num++
+			i = j
+			continue
+		}
+
+		b.buf.WriteString(sql[i:j])
+		i = j
+	}
+
+	return num
+}
+
+func (b *stmtBinder) value(v interface{}) {
+
+	if e, ok := v.(Expr); ok {
+		named := b.named
+		b.named = nil
+		if num := b.bind(e.sql, e.args); num != len(e.args) && b.err == nil {
+			b.err = fmt.Errorf("Expr %q has %d placeholders, got %d args", e.sql, num, len(e.args))
+		}
+		b.named = named
+		return
+	}
+
+	if !b.strict {
+		if vf := dialectStmtBindVarFunc(v); vf != "" {
+			b.buf.WriteString(vf)
+			return
+		}
+	}
+
+	b.args = append(b.args, v)
+	b.buf.WriteString("$" + strconv.Itoa(len(b.args)))
+}
+
+// stmtIsEscapeString reports whether the quote at i starts an E'...' string,
+// in which a backslash escapes the next character.
+func stmtIsEscapeString(sql string, i int) bool {
+	if i == 0 || (sql[i-1] != 'E' && sql[i-1] != 'e') {
+		return false
+	}
+	return i == 1 || !stmtIsIdentChar(sql[i-2])
+}
+
+// stmtScanString returns the position after the string or quoted
+// identifier starting at i, a doubled quote is part of it.
+func stmtScanString(sql string, i int, backslash bool) int {
+	quote := sql[i]
+	for j := i + 1; j < len(sql); j++ {
+		switch sql[j] {
+		case '\\':
+			if backslash {
+				j++
+			}
+		case quote:
+			if j+1 < len(sql) && sql[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1
+		}
+	}
+	return len(sql)
+}
+
+// stmtScanComment returns the position after the, possibly nested,
+// /* ... */ comment starting at i.
+func stmtScanComment(sql string, i int) int {
+	depth := 0
+	for j := i; j+1 < len(sql); j++ {
+		switch {
+		case sql[j] == '/' && sql[j+1] == '*':
+			depth++
+			j++
+		case sql[j] == '*' && sql[j+1] == '/':
+			depth--
+			j++
+			if depth == 0 {
+				return j + 1
+			}
+		}
+	}
+	return len(sql)
+}
+
+// stmtParamNum returns n of the $n parameter at i, or 0 if there is none.
+func stmtParamNum(sql string, i int) int {
+	if i > 0 && stmtIsIdentChar(sql[i-1]) {
+		return 0
+	}
+	j := i + 1
+	for j < len(sql) && sql[j] >= '0' && sql[j] <= '9' {
+		j++
+	}
+	if j == i+1 || (j < len(sql) && stmtIsIdentChar(sql[j])) {
+		return 0
+	}
+	n, _ := strconv.Atoi(sql[i+1 : j])
+	return n
+}
+
+// stmtDollarTag returns the tag, e.g. "$$" or "$fn$", of the dollar-quoted
+// string starting at i, or "" if there is none, e.g. for "$1".
+func stmtDollarTag(sql string, i int) string {
+	if i > 0 && stmtIsIdentChar(sql[i-1]) {
+		return ""
+	}
+	for j := i + 1; j < len(sql); j++ {
+		c := sql[j]
+		if c == '$' {
+			return sql[i : j+1]
+		}
+		if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+			(j > i+1 && c >= '0' && c <= '9') || c >= 0x80) {
+			return ""
+		}
+	}
+	return ""
+}
+
+func stmtIsIdentChar(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') ||
+		(c >= '0' && c <= '9') || c >= 0x80
+}
+
+// namedValue writes the parameter of name, a repeated name reuses the
+// position of its first use.
+func (b *stmtBinder) namedValue(name string) {
+
+	if pos, ok := b.positions[name]; ok {
+		b.buf.WriteString("$" + strconv.Itoa(pos))
+		return
+	}
+
+	v, ok := b.named[name]
+	if !ok {
+		if b.err == nil {
+			b.err = fmt.Errorf("Missing named parameter %s", name)
+		}
+		b.missing++
+		b.buf.WriteString("$" + strconv.Itoa(len(b.args)+b.missing))
+		return
+	}
+
+	// an Expr is inlined at each use
+	b.value(v)
+	if _, ok := v.(Expr); !ok {
+		b.positions[name] = len(b.args)
+	}
+}
+
+// dialectStmtBindNamed rewrites the :name and @name parameters of sql into
+// positional parameters, bound from arg, a map[string]interface{} or a
+// struct (or pointer to one) mapped by its `db` tags like in ScanStruct.
+// A repeated name is bound once, "::type" casts are kept, and "?" is not a
+// placeholder here. The lexing and binding rules of dialectStmtBind apply,
+// in strict mode.
+func dialectStmtBindNamed(sql string, arg interface{}) (string, []interface{}, error) {
+
+	named, err := stmtNamedArgs(arg)
+	if err != nil {
+		return "", nil, err
+	}
+
+	b := &stmtBinder{
+		strict:    true,
+		named:     named,
+		positions: map[string]int{},
+	}
+	b.buf.Grow(len(sql) + 8)
+
+	b.bind(sql, nil)
+
+	return b.buf.String(), b.args, b.err
+}
+
+func stmtNamedArgs(arg interface{}) (map[string]interface{}, error) {
+
+	if arg == nil {
+		return map[string]interface{}{}, nil
+	}
+
+	if m, ok := arg.(map[string]interface{}); ok {
+		return m, nil
+	}
+
+	rv := reflect.ValueOf(arg)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return nil, errors.New("Invalid named args, must be a map[string]interface{} or a struct")
+	}
+
+	named := map[string]interface{}{}
+
+	for name, sf := range structPlan(rv.Type()) {
+		if fv, ok := structFieldRead(rv, sf.index); ok {
+			if sf.json {
+				bs, err := json.Marshal(fv.Interface())
+				if err != nil {
+					return nil, err
+				}
+				named[name] = bs
+			} else {
+				named[name] = fv.Interface()
+			}
+		}
+	}
+
+	return named, nil
+}
+
+// stmtIsNameStart reports whether a parameter name starts at i.
+func stmtIsNameStart(sql string, i int) bool {
+	if i >= len(sql) {
+		return false
+	}
+	c := sql[i]
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
@@ -0,0 +1,271 @@
+// Copyright 2018 Eryx <evorui аt gmail dοt com>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgsqlgo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStmtBindLexer(t *testing.T) {
+
+	tests := []struct {
+		sql   string
+		vars  []interface{}
+		want  string
+		args  []interface{}
+		isErr bool
+	}{
+		{
+			sql:  "SELECT * FROM t WHERE a = ? AND b = ?",
+			vars: []interface{}{1, 2},
+			want: "SELECT * FROM t WHERE a = $1 AND b = $2",
+			args: []interface{}{1, 2},
+		},
+		{
+			sql:  "SELECT 'a?''b?' FROM t WHERE a = ?",
+			vars: []interface{}{1},
+			want: "SELECT 'a?''b?' FROM t WHERE a = $1",
+			args: []interface{}{1},
+		},
+		{
+			sql:  `SELECT "col?" FROM "t""?" WHERE a = ?`,
+			vars: []interface{}{1},
+			want: `SELECT "col?" FROM "t""?" WHERE a = $1`,
+			args: []interface{}{1},
+		},
+		{
+			sql:  `SELECT E'\'?' , e'x\\' WHERE a = ?`,
+			vars: []interface{}{1},
+			want: `SELECT E'\'?' , e'x\\' WHERE a = $1`,
+			args: []interface{}{1},
+		},
+		{
+			sql:  "SELECT $$ ? $$, $fn$ '? $$ $fn$ WHERE a = ?",
+			vars: []interface{}{1},
+			want: "SELECT $$ ? $$, $fn$ '? $$ $fn$ WHERE a = $1",
+			args: []interface{}{1},
+		},
+		{
+			sql:  "SELECT 1 /* ? /* ? */ ? */ -- ?\nWHERE a = ?",
+			vars: []interface{}{1},
+			want: "SELECT 1 /* ? /* ? */ ? */ -- ?\nWHERE a = $1",
+			args: []interface{}{1},
+		},
+		{
+			sql:  "SELECT * FROM t WHERE d ?| array['a'] AND d ?& array['b'] AND a = ?",
+			vars: []interface{}{1},
+			want: "SELECT * FROM t WHERE d ?| array['a'] AND d ?& array['b'] AND a = $1",
+			args: []interface{}{1},
+		},
+		{
+			sql:  "SELECT * FROM t WHERE d ?? 'k' AND a = ?",
+			vars: []interface{}{1},
+			want: "SELECT * FROM t WHERE d ? 'k' AND a = $1",
+			args: []interface{}{1},
+		},
+		{
+			sql:  "SELECT * FROM t WHERE d ?? 'k' AND a = $1",
+			vars: []interface{}{1},
+			want: "SELECT * FROM t WHERE d ? 'k' AND a = $1",
+			args: []interface{}{1},
+		},
+		{
+			sql:   "DELETE FROM t",
+			vars:  []interface{}{5},
+			want:  "DELETE FROM t",
+			args:  []interface{}{5},
+			isErr: true,
+		},
+		{
+			sql:   "SELECT * FROM t WHERE a = ? AND x = $1",
+			vars:  []interface{}{1},
+			want:  "SELECT * FROM t WHERE a = $1 AND x = $1",
+			args:  []interface{}{1},
+			isErr: true,
+		},
+		{
+			sql:   "SELECT * FROM t WHERE a = $1 AND b = $2",
+			vars:  []interface{}{1},
+			want:  "SELECT * FROM t WHERE a = $1 AND b = $2",
+			args:  []interface{}{1},
+			isErr: true,
+		},
+		{
+			sql:   "SELECT * FROM t WHERE a = ? AND b = ?",
+			vars:  []interface{}{1},
+			want:  "SELECT * FROM t WHERE a = $1 AND b = $2",
+			args:  []interface{}{1},
+			isErr: true,
+		},
+	}
+
+	for _, v := range tests {
+		sql, args, err := dialectStmtBind(v.sql, v.vars, true)
+		if sql != v.want || !reflect.DeepEqual(args, v.args) || (err != nil) != v.isErr {
+			t.Errorf("dialectStmtBind(%q)\n got %q %v %v\nwant %q %v error %v",
+				v.sql, sql, args, err, v.want, v.args, v.isErr)
+		}
+	}
+}
+
+func TestStmtBindStrict(t *testing.T) {
+
+	const hostile = "count(1); DROP TABLE x --"
+
+	tests := []struct {
+		vars   []interface{}
+		strict bool
+		want   string
+		args   []interface{}
+	}{
+		{
+			vars:   []interface{}{hostile},
+			strict: true,
+			want:   "SELECT * FROM t WHERE a = $1",
+			args:   []interface{}{hostile},
+		},
+		{
+			vars:   []interface{}{"'; DROP TABLE x --"},
+			strict: false,
+			want:   "SELECT * FROM t WHERE a = $1",
+			args:   []interface{}{"'; DROP TABLE x --"},
+		},
+		{
+			// the legacy BindVar inlines whitelisted function strings
+			vars:   []interface{}{"count(1)"},
+			strict: false,
+			want:   "SELECT * FROM t WHERE a = count(1)",
+		},
+		{
+			vars:   []interface{}{"count(1)"},
+			strict: true,
+			want:   "SELECT * FROM t WHERE a = $1",
+			args:   []interface{}{"count(1)"},
+		},
+		{
+			vars:   []interface{}{Func("lower", hostile)},
+			strict: true,
+			want:   "SELECT * FROM t WHERE a = lower($1)",
+			args:   []interface{}{hostile},
+		},
+		{
+			vars:   []interface{}{Raw("now() - ?::interval", "1 day")},
+			strict: true,
+			want:   "SELECT * FROM t WHERE a = now() - $1::interval",
+			args:   []interface{}{"1 day"},
+		},
+	}
+
+	for _, v := range tests {
+		sql, args, err := dialectStmtBind("SELECT * FROM t WHERE a = ?", v.vars, v.strict)
+		if err != nil || sql != v.want || !reflect.DeepEqual(args, v.args) {
+			t.Errorf("dialectStmtBind(%v, strict %v)\n got %q %v %v\nwant %q %v",
+				v.vars, v.strict, sql, args, err, v.want, v.args)
+		}
+	}
+}
+
+type NamedTestBase struct {
+	Org int64 `db:"org"`
+}
+
+type testNamedUser struct {
+	*NamedTestBase
+	ID   int64    `db:"id"`
+	Name string   `db:"name"`
+	Tags []string `db:"tags,json"`
+	Note string   `db:"-"`
+}
+
+func TestStmtBindNamed(t *testing.T) {
+
+	tests := []struct {
+		sql   string
+		arg   interface{}
+		want  string
+		args  []interface{}
+		isErr bool
+	}{
+		{
+			sql:  "SELECT * FROM t WHERE a = :a AND b = @b AND c = :a",
+			arg:  map[string]interface{}{"a": 1, "b": "x"},
+			want: "SELECT * FROM t WHERE a = $1 AND b = $2 AND c = $1",
+			args: []interface{}{1, "x"},
+		},
+		{
+			sql:  "SELECT :a::int, a::text, ':b', \":b\" -- :b\nFROM t /* :b */ WHERE $$ :b $$ <> ''",
+			arg:  map[string]interface{}{"a": 1},
+			want: "SELECT $1::int, a::text, ':b', \":b\" -- :b\nFROM t /* :b */ WHERE $$ :b $$ <> ''",
+			args: []interface{}{1},
+		},
+		{
+			sql:  "SELECT * FROM t WHERE d @> :d AND d <@ @d AND v @@ to_tsquery(:q) AND d ? 'k'",
+			arg:  map[string]interface{}{"d": "{}", "q": "x"},
+			want: "SELECT * FROM t WHERE d @> $1 AND d <@ $1 AND v @@ to_tsquery($2) AND d ? 'k'",
+			args: []interface{}{"{}", "x"},
+		},
+		{
+			sql:  "SELECT arr[lo:hi], arr[:hi], arr[1:2], arr[:i + 1] FROM t WHERE id = :id",
+			arg:  map[string]interface{}{"id": 1},
+			want: "SELECT arr[lo:hi], arr[:hi], arr[1:2], arr[:i + 1] FROM t WHERE id = $1",
+			args: []interface{}{1},
+		},
+		{
+			sql:  "UPDATE t SET at = :at, n = :n WHERE n <> :n",
+			arg:  map[string]interface{}{"at": Now(), "n": "x"},
+			want: "UPDATE t SET at = now(), n = $1 WHERE n <> $1",
+			args: []interface{}{"x"},
+		},
+		{
+			sql:  "UPDATE t SET name = :name, tags = :tags WHERE id = :id",
+			arg:  &testNamedUser{ID: 2, Name: "x", Tags: []string{"a"}},
+			want: "UPDATE t SET name = $1, tags = $2 WHERE id = $3",
+			args: []interface{}{"x", []byte(`["a"]`), int64(2)},
+		},
+		{
+			sql:  "SELECT :org",
+			arg:  testNamedUser{NamedTestBase: &NamedTestBase{Org: 3}},
+			want: "SELECT $1",
+			args: []interface{}{int64(3)},
+		},
+		{
+			// nil embedded struct
+			sql:   "SELECT :org",
+			arg:   testNamedUser{},
+			want:  "SELECT $1",
+			isErr: true,
+		},
+		{
+			sql:   "SELECT :note",
+			arg:   testNamedUser{},
+			want:  "SELECT $1",
+			isErr: true,
+		},
+		{
+			sql:   "SELECT :a",
+			arg:   1,
+			isErr: true,
+		},
+	}
+
+	for _, v := range tests {
+		sql, args, err := dialectStmtBindNamed(v.sql, v.arg)
+		if sql != v.want || !reflect.DeepEqual(args, v.args) || (err != nil) != v.isErr {
+			t.Errorf("dialectStmtBindNamed(%q)\n got %q %v %v\nwant %q %v error %v",
+				v.sql, sql, args, err, v.want, v.args, v.isErr)
+		}
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
 
 	_ "github.com/lib/pq"
 	"github.com/lynkdb/iomix/connect"
@@ -55,6 +56,9 @@ func NewConnector(cfg connect.ConnOptions) (rdb.Connector, error) {
 		return nil, err
 	}
 	base.BindVar = dialectStmtBindVar
+	if cfg.Value("bind_strict") == "true" {
+		base.BindVar = dialectStmtBindVarStrict
+	}
 	base.QuoteStr = dialectQuoteStr
 	base.TypeDatetimeFmt = dialectDatetimeFmt
 
@@ -62,8 +66,21 @@ func NewConnector(cfg connect.ConnOptions) (rdb.Connector, error) {
 		base.StmtSet(k, v)
 	}
 
-	return &Dialect{
-		Base:   *base,
-		dbName: cfg.Value("dbname"),
-	}, nil
+	dc := &Dialect{
+		Base:       *base,
+		dbName:     cfg.Value("dbname"),
+		bindStrict: cfg.Value("bind_strict") == "true",
+	}
+
+	stmtCacheSize := stmtCacheSizeDefault
+	if v := cfg.Value("stmt_cache_size"); v != "" {
+		if stmtCacheSize, err = strconv.Atoi(v); err != nil {
+			return nil, errors.New("Invalid stmt_cache_size")
+		}
+	}
+	if stmtCacheSize > 0 {
+		dc.stmts = newStmtCache(db, stmtCacheSize)
+	}
+
+	return dc, nil
 }
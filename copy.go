@@ -0,0 +1,159 @@
+// Copyright 2018 Eryx <evorui аt gmail dοt com>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgsqlgo
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// CopySource provides the rows of Dialect.CopyIn, the values of a row are
+// in the order of the copied columns.
+type CopySource interface {
+	Next() bool
+	Values() ([]interface{}, error)
+	Err() error
+}
+
+type copySliceSource struct {
+	rows [][]interface{}
+	pos  int
+}
+
+// CopyFromRows returns a CopySource reading rows from a slice.
+func CopyFromRows(rows [][]interface{}) CopySource {
+	return &copySliceSource{
+		rows: rows,
+		pos:  -1,
+	}
+}
+
+func (src *copySliceSource) Next() bool {
+	src.pos++
+	return src.pos < len(src.rows)
+}
+
+func (src *copySliceSource) Values() ([]interface{}, error) {
+	return src.rows[src.pos], nil
+}
+
+func (src *copySliceSource) Err() error {
+	return nil
+}
+
+type copyChanSource struct {
+	ch  <-chan []interface{}
+	row []interface{}
+}
+
+// CopyFromChan returns a CopySource reading rows from ch until it is closed.
+func CopyFromChan(ch <-chan []interface{}) CopySource {
+	return &copyChanSource{
+		ch: ch,
+	}
+}
+
+func (src *copyChanSource) Next() bool {
+	row, ok := <-src.ch
+	src.row = row
+	return ok
+}
+
+func (src *copyChanSource) Values() ([]interface{}, error) {
+	return src.row, nil
+}
+
+func (src *copyChanSource) Err() error {
+	return nil
+}
+
+// CopyIn loads the rows of src into the columns of table, which may be
+// qualified by its schema, through COPY FROM STDIN in one transaction, and
+// returns the number of rows loaded.
+func (dc *Dialect) CopyIn(table string, cols []string, src CopySource) (int64, error) {
+
+	tx, err := dc.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	num, err := tx.CopyIn(table, cols, src)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return num, nil
+}
+
+// CopyIn is like Dialect.CopyIn, inside the transaction.
+func (tx *Tx) CopyIn(table string, cols []string, src CopySource) (int64, error) {
+
+	query := pq.CopyIn(table, cols...)
+	if n := strings.IndexByte(table, '.'); n > 0 {
+		query = pq.CopyInSchema(table[:n], table[n+1:], cols...)
+	}
+
+	stmt, err := tx.tx.Prepare(query)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var num int64
+
+	for src.Next() {
+
+		vals, err := src.Values()
+		if err != nil {
+			return num, fmt.Errorf("Copy row %d: %s", num+1, err.Error())
+		}
+
+		if len(vals) != len(cols) {
+			return num, fmt.Errorf("Copy row %d: %d values for %d columns", num+1, len(vals), len(cols))
+		}
+
+		if _, err = stmt.Exec(vals...); err != nil {
+			return num, copyError(table, num+1, err)
+		}
+		num++
+	}
+
+	if err = src.Err(); err != nil {
+		return num, err
+	}
+
+	// flush the stream and get the errors of the server
+	if _, err = stmt.Exec(); err != nil {
+		return num, copyError(table, num, err)
+	}
+
+	return num, nil
+}
+
+// copyError names the failing row, which is reported by the server in the
+// context of the error, e.g. "COPY users, line 3, column age: ...".
+func copyError(table string, row int64, err error) error {
+	if pe, ok := err.(*pq.Error); ok && pe.Where != "" {
+		return fmt.Errorf("Copy into %s failed (%s): %s", table, pe.Where, pe.Message)
+	}
+	return fmt.Errorf("Copy into %s failed near row %d: %s", table, row, err.Error())
+}
@@ -0,0 +1,198 @@
+// Copyright 2018 Eryx <evorui аt gmail dοt com>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgsqlgo
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/lynkdb/iomix/rdb"
+)
+
+const (
+	cursorBatchDefault = 1000
+)
+
+var cursorSeq uint64
+
+// Cursor reads the result of a query through a server-side cursor in
+// batches of FETCH, so that huge results neither stay in memory nor keep
+// one round trip open for the whole read. The caller must Close it.
+type Cursor struct {
+	tx     *Tx
+	ownTx  bool
+	name   string
+	batch  int
+	rows   *Rows
+	num    int
+	done   bool
+	closed bool
+	err    error
+}
+
+// Cursor opens a cursor for q in a transaction of its own, which is
+// committed by Cursor.Close. All matching rows are read, unless a limit is
+// set by Limit.
+func (dc *Dialect) Cursor(q rdb.Queryer, batch int) (*Cursor, error) {
+
+	tx, err := dc.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	cur, err := tx.Cursor(q, batch)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	cur.ownTx = true
+
+	return cur, nil
+}
+
+// Cursor opens a cursor for q in the transaction, it is valid until
+// Close or the end of the transaction.
+func (tx *Tx) Cursor(q rdb.Queryer, batch int) (*Cursor, error) {
+
+	if err := queryCheck(q, true); err != nil {
+		return nil, err
+	}
+
+	if batch <= 0 {
+		batch = cursorBatchDefault
+	}
+
+	cur := &Cursor{
+		tx:    tx,
+		name:  fmt.Sprintf("pgsqlgo_cursor_%d", atomic.AddUint64(&cursorSeq, 1)),
+		batch: batch,
+	}
+
+	query, args := querySubParse(tx.dc.softDeleteScope(q))
+	query, args, err := dialectStmtBind("DECLARE "+cur.name+" NO SCROLL CURSOR FOR "+query, args, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// the cursor name is unique, keep it out of the statement cache
+	if _, err = tx.tx.Exec(query, args...); err != nil {
+		return nil, err
+	}
+
+	return cur, nil
+}
+
+// Next prepares the next row for Scan, fetching the next batch from the
+// server when the current one is used up.
+func (cur *Cursor) Next() bool {
+
+	for !cur.done && cur.err == nil {
+
+		if cur.rows != nil {
+
+			if cur.rows.Next() {
+				cur.num++
+				return true
+			}
+
+			cur.err = cur.rows.Err()
+			cur.rows.Close()
+			cur.rows = nil
+
+			if cur.num < cur.batch {
+				cur.done = true
+			}
+			continue
+		}
+
+		cur.num = 0
+		cur.rows, cur.err = cur.tx.QueryRaw(fmt.Sprintf("FETCH %d FROM %s", cur.batch, cur.name))
+	}
+
+	return false
+}
+
+func (cur *Cursor) Scan(dest ...interface{}) error {
+	if cur.rows == nil {
+		return errors.New("Scan called without calling Next")
+	}
+	return cur.rows.Scan(dest...)
+}
+
+func (cur *Cursor) Columns() ([]string, error) {
+	if cur.rows == nil {
+		return nil, errors.New("Columns called without calling Next")
+	}
+	return cur.rows.Columns()
+}
+
+// All returns an iterator over the rows for range loops, the cursor is
+// closed when the loop ends, also by break or return, e.g.
+//
+//	for cur := range cur.All() {
+//		if cur.Scan(&id); id == 42 {
+//			break
+//		}
+//	}
+//	if err := cur.Err(); err != nil {
+//		return err
+//	}
+func (cur *Cursor) All() func(yield func(*Cursor) bool) {
+	return func(yield func(*Cursor) bool) {
+		defer func() {
+			if err := cur.Close(); err != nil && cur.err == nil {
+				cur.err = err
+			}
+		}()
+		for cur.Next() {
+			if !yield(cur) {
+				return
+			}
+		}
+	}
+}
+
+func (cur *Cursor) Err() error {
+	return cur.err
+}
+
+// Close closes the cursor, also when the rows have not been read to the end,
+// and finishes the transaction opened by Dialect.Cursor.
+func (cur *Cursor) Close() error {
+
+	if cur.closed {
+		return nil
+	}
+	cur.closed = true
+	cur.done = true
+
+	if cur.rows != nil {
+		cur.rows.Close()
+		cur.rows = nil
+	}
+
+	_, err := cur.tx.ExecRaw("CLOSE " + cur.name)
+
+	if cur.ownTx {
+		if err != nil || cur.err != nil {
+			cur.tx.Rollback()
+		} else {
+			err = cur.tx.Commit()
+		}
+	}
+
+	return err
+}
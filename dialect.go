@@ -16,8 +16,8 @@ package pgsqlgo
 
 import (
 	"fmt"
-	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/lynkdb/iomix/rdb"
 	"github.com/lynkdb/iomix/rdb/modeler"
@@ -109,6 +109,8 @@ var dialectStmts = map[string]string{
 	"insertIgnore": "INSERT INTO %s (%s) VALUES (%s) ON CONFLICT DO NOTHING", // >= v9.5
 }
 
+// dialectAllowFuncs are the functions which may be written as is in select
+// columns and, by the legacy BindVar, in bound values.
 var dialectAllowFuncs = map[string]bool{
 	"COUNT":   true,
 	"SUM":     true,
@@ -122,21 +124,60 @@ var dialectAllowFuncs = map[string]bool{
 	"LASTVAL": true,
 }
 
-func dialectStmtBindVar(sql string, vars []interface{}) (string, []interface{}) {
-	var (
-		num = 0
-		rs  []interface{}
-	)
-	for _, v := range vars {
-		if vf := dialectStmtBindVarFunc(v); vf != "" {
-			sql = strings.Replace(sql, "?", vf, 1)
-		} else {
-			num += 1
-			sql = strings.Replace(sql, "?", "$"+strconv.Itoa(num), 1)
-			rs = append(rs, v)
+var (
+	dialectSelectFuncsMu sync.RWMutex
+	dialectSelectFuncs   = map[string]bool{}
+)
+
+// RegisterFunc adds SQL function names to the whitelist of functions that
+// may be written as is in select columns, e.g. "AVG", "COALESCE" or
+// "DATE_TRUNC". Names are case insensitive. Bound values are not affected,
+// use Func to pass a function call as a value.
+func RegisterFunc(names ...string) {
+	dialectSelectFuncsMu.Lock()
+	defer dialectSelectFuncsMu.Unlock()
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			dialectSelectFuncs[strings.ToUpper(name)] = true
 		}
 	}
-	return sql, rs
+}
+
+func dialectAllowFunc(name string) bool {
+	return dialectAllowFuncs[strings.ToUpper(name)]
+}
+
+func dialectSelectFunc(name string) bool {
+	if dialectAllowFunc(name) {
+		return true
+	}
+	dialectSelectFuncsMu.RLock()
+	defer dialectSelectFuncsMu.RUnlock()
+	return dialectSelectFuncs[strings.ToUpper(name)]
+}
+
+// dialectStmtBindVar is the BindVar of rdb.Base, see dialectStmtBind. A
+// placeholder mismatch is left to be reported by the driver, as BindVar
+// can not return an error. This applies to the statements which rdb.Base
+// binds itself, i.e. Query, Fetch, Insert, InsertIgnore, Update, Delete,
+// HardDelete and Count of a table which is not in soft-delete mode, while
+// ExecRaw, QueryRaw and the methods added by this package report it.
+//
+// It keeps inlining whitelisted function strings for compatibility, so a
+// user supplied value like "count(1); DROP TABLE x --" is spliced into the
+// statement. Set the "bind_strict" option of the connector to "true" to use
+// dialectStmtBindVarStrict instead, and pass functions as Func values.
+func dialectStmtBindVar(sql string, vars []interface{}) (string, []interface{}) {
+	sql, vars, _ = dialectStmtBind(sql, vars, false)
+	return sql, vars
+}
+
+// dialectStmtBindVarStrict is the strict BindVar of rdb.Base, every plain
+// string is bound as a parameter. The methods added by this package, e.g.
+// Iter, Upsert, Tx and Stmt, always bind strictly.
+func dialectStmtBindVarStrict(sql string, vars []interface{}) (string, []interface{}) {
+	sql, vars, _ = dialectStmtBind(sql, vars, true)
+	return sql, vars
 }
 
 func dialectStmtBindVarFunc(val interface{}) string {
@@ -144,7 +185,7 @@ func dialectStmtBindVarFunc(val interface{}) string {
 	case string:
 		vs := val.(string)
 		if n := strings.IndexByte(vs, '('); n > 0 {
-			if dialectAllowFuncs[strings.ToUpper(vs[:n])] {
+			if dialectAllowFunc(vs[:n]) {
 				return vs
 			}
 		}
@@ -159,18 +200,26 @@ func dialectQuoteStr(name string) string {
 	}
 
 	if n := strings.IndexByte(name, '('); n > 0 {
-		upName := strings.ToUpper(name)
-		if dialectAllowFuncs[upName[:n]] {
+		if dialectSelectFunc(name[:n]) {
 			return name
 		}
 	}
 
+	// qualified name, e.g. "t.id" or "t.*"
+	if n := strings.IndexByte(name, '.'); n > 0 {
+		return dialectQuoteStr(name[:n]) + "." + dialectQuoteStr(name[n+1:])
+	}
+
 	return dialectQuote + name + dialectQuote
 }
 
 type Dialect struct {
 	rdb.Base
-	dbName string
+	dbName      string
+	bindStrict  bool
+	softMu      sync.RWMutex
+	softDeletes map[string]string
+	stmts       *stmtCache
 }
 
 func (dc *Dialect) DBName() string {
@@ -195,6 +244,23 @@ func (dc *Dialect) NewQueryer() rdb.Queryer {
 	return NewQueryer()
 }
 
+func (dc *Dialect) Query(q rdb.Queryer) ([]*rdb.Entry, error) {
+	if err := queryCheck(q, false); err != nil {
+		return nil, err
+	}
+	return dc.Base.Query(dc.softDeleteScope(q))
+}
+
+func (dc *Dialect) Fetch(q rdb.Queryer) (*rdb.Entry, error) {
+	if err := queryCheck(q, false); err != nil {
+		return nil, err
+	}
+	return dc.Base.Fetch(dc.softDeleteScope(q))
+}
+
 func (dc *Dialect) Close() {
+	if dc.stmts != nil {
+		dc.stmts.close()
+	}
 	dc.Base.Close()
 }
@@ -0,0 +1,176 @@
+// Copyright 2018 Eryx <evorui аt gmail dοt com>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgsqlgo
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/lynkdb/iomix/rdb"
+)
+
+type ExplainOptions struct {
+	Analyze bool // run the statement and report the actual times and rows
+	Buffers bool // report the buffer usage, requires Analyze before v13
+	Verbose bool
+}
+
+type ExplainPlan struct {
+	Plan          *ExplainNode `json:"Plan"`
+	PlanningTime  float64      `json:"Planning Time"`
+	ExecutionTime float64      `json:"Execution Time"`
+}
+
+type ExplainNode struct {
+	NodeType          string         `json:"Node Type"`
+	RelationName      string         `json:"Relation Name"`
+	Schema            string         `json:"Schema"` // reported with Verbose
+	Alias             string         `json:"Alias"`
+	IndexName         string         `json:"Index Name"`
+	JoinType          string         `json:"Join Type"`
+	Filter            string         `json:"Filter"`
+	IndexCond         string         `json:"Index Cond"`
+	StartupCost       float64        `json:"Startup Cost"`
+	TotalCost         float64        `json:"Total Cost"`
+	PlanRows          float64        `json:"Plan Rows"`
+	PlanWidth         int            `json:"Plan Width"`
+	ActualStartupTime float64        `json:"Actual Startup Time"`
+	ActualTotalTime   float64        `json:"Actual Total Time"`
+	ActualRows        float64        `json:"Actual Rows"`
+	ActualLoops       float64        `json:"Actual Loops"`
+	SharedHitBlocks   int64          `json:"Shared Hit Blocks"`
+	SharedReadBlocks  int64          `json:"Shared Read Blocks"`
+	Plans             []*ExplainNode `json:"Plans"`
+}
+
+// Explain runs EXPLAIN for q with its real parameters and returns the parsed
+// plan. With Analyze the statement is executed inside a transaction which is
+// rolled back afterwards.
+func (dc *Dialect) Explain(q rdb.Queryer, opts *ExplainOptions) (*ExplainPlan, error) {
+
+	if opts == nil {
+		opts = &ExplainOptions{}
+	}
+
+	var (
+		sets        = []string{"FORMAT JSON"}
+		query, args = dc.softDeleteScope(q).Parse()
+	)
+
+	if opts.Analyze {
+		sets = append(sets, "ANALYZE")
+	}
+	if opts.Buffers {
+		sets = append(sets, "BUFFERS")
+	}
+	if opts.Verbose {
+		sets = append(sets, "VERBOSE")
+	}
+
+	tx, err := dc.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryRaw(fmt.Sprintf("EXPLAIN (%s) %s", strings.Join(sets, ", "), query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var js []byte
+	if rows.Next() {
+		if err = rows.Scan(&js); err != nil {
+			return nil, err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var plans []*ExplainPlan
+	if err = json.Unmarshal(js, &plans); err != nil {
+		return nil, err
+	}
+
+	if len(plans) == 0 || plans[0].Plan == nil {
+		return nil, errors.New("Empty Explain Result")
+	}
+
+	return plans[0], nil
+}
+
+// Walk calls fn for each node of the plan tree, parents first.
+func (p *ExplainPlan) Walk(fn func(node *ExplainNode)) {
+	if p.Plan != nil {
+		p.Plan.walk(fn)
+	}
+}
+
+func (n *ExplainNode) walk(fn func(node *ExplainNode)) {
+	fn(n)
+	for _, v := range n.Plans {
+		v.walk(fn)
+	}
+}
+
+// SeqScans returns the sequential scan nodes of the plan.
+func (p *ExplainPlan) SeqScans() []*ExplainNode {
+	nodes := []*ExplainNode{}
+	p.Walk(func(node *ExplainNode) {
+		if node.NodeType == "Seq Scan" {
+			nodes = append(nodes, node)
+		}
+	})
+	return nodes
+}
+
+// CheckSeqScan returns an error if the plan scans a table with at least
+// minRows rows (as estimated by the planner statistics) sequentially,
+// e.g. to assert in tests that a query is covered by an index. Tables are
+// looked up in the search path, unless the plan is explained with Verbose,
+// which reports their schema. A table which has never been analyzed has no
+// estimate and is reported as an error too.
+func (dc *Dialect) CheckSeqScan(p *ExplainPlan, minRows int64) error {
+
+	for _, node := range p.SeqScans() {
+
+		name := pq.QuoteIdentifier(node.RelationName)
+		if node.Schema != "" {
+			name = pq.QuoteIdentifier(node.Schema) + "." + name
+		}
+
+		var rows float64
+		err := dc.DB().QueryRow(
+			"SELECT reltuples FROM pg_class WHERE oid = $1::regclass", name).Scan(&rows)
+		if err != nil {
+			return err
+		}
+
+		if rows < 0 {
+			return fmt.Errorf("Unknown row count of table %s, run ANALYZE first", name)
+		}
+
+		if int64(rows) >= minRows {
+			return fmt.Errorf("Sequential scan on table %s (%d rows)", node.RelationName, int64(rows))
+		}
+	}
+
+	return nil
+}
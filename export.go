@@ -0,0 +1,249 @@
+// Copyright 2018 Eryx <evorui аt gmail dοt com>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgsqlgo
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/lynkdb/iomix/rdb"
+)
+
+type ExportFormat int
+
+const (
+	ExportText      ExportFormat = iota // tab separated, \N for NULL
+	ExportCSV                           // comma separated, unquoted empty value for NULL
+	ExportJSONLines                     // one json object per row
+)
+
+type ExportOptions struct {
+	Format ExportFormat
+	Header bool // add a header line with the column names to ExportCSV
+}
+
+// Export writes the result of q to w in the format of
+// COPY (query) TO STDOUT, and returns the number of rows written. All
+// matching rows are written, unless a limit is set by Limit.
+//
+// The vendored driver does not support COPY TO, so the query is run once by
+// a SELECT of each row as its record text, whose values are rendered on the
+// server by the same output functions as COPY does, and encoded here the
+// way COPY does. The header of ExportCSV takes the column names from the
+// first row, so it is left out for an empty result.
+func (dc *Dialect) Export(w io.Writer, q rdb.Queryer, opts *ExportOptions) (int64, error) {
+
+	if opts == nil {
+		opts = &ExportOptions{}
+	}
+
+	if err := queryCheck(q, false); err != nil {
+		return 0, err
+	}
+
+	query, params := querySubParse(dc.softDeleteScope(q))
+
+	if opts.Format == ExportJSONLines {
+		return dc.exportJSONLines(w, query, params)
+	}
+
+	if opts.Format != ExportText && opts.Format != ExportCSV {
+		return 0, errors.New("Invalid Export Format")
+	}
+
+	header := opts.Format == ExportCSV && opts.Header
+
+	sel := "pgsqlgo_export::text"
+	if header {
+		sel += ", CASE WHEN row_number() OVER () = 1 THEN " +
+			"(SELECT json_agg(k) FROM json_object_keys(row_to_json(pgsqlgo_export)) AS k) END"
+	}
+
+	rows, err := dc.IterRaw("SELECT "+sel+" FROM ("+query+") AS pgsqlgo_export", params...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var (
+		bw     = bufio.NewWriter(w)
+		num    int64
+		record string
+		names  []byte
+		dest   = []interface{}{&record}
+		sep    = "\t"
+	)
+
+	if header {
+		dest = append(dest, &names)
+	}
+	if opts.Format == ExportCSV {
+		sep = ","
+	}
+
+	for rows.Next() {
+
+		if err = rows.Scan(dest...); err != nil {
+			return num, err
+		}
+
+		vals, err := exportRecordParse(record)
+		if err != nil {
+			return num, err
+		}
+
+		if num == 0 && header {
+			var cols []string
+			if err = json.Unmarshal(names, &cols); err != nil {
+				return num, err
+			}
+			line := make([]string, len(cols))
+			for i, col := range cols {
+				line[i] = exportCSVValue(sql.NullString{String: col, Valid: true})
+			}
+			if _, err = bw.WriteString(strings.Join(line, ",") + "\n"); err != nil {
+				return num, err
+			}
+		}
+
+		line := make([]string, len(vals))
+		for i, v := range vals {
+			if opts.Format == ExportCSV {
+				line[i] = exportCSVValue(v)
+			} else {
+				line[i] = exportTextValue(v)
+			}
+		}
+
+		if _, err = bw.WriteString(strings.Join(line, sep) + "\n"); err != nil {
+			return num, err
+		}
+		num++
+	}
+
+	if err = rows.Err(); err != nil {
+		return num, err
+	}
+
+	return num, bw.Flush()
+}
+
+// exportRecordParse splits the text of a record, e.g. (1,"a b",) into
+// its values, an empty unquoted value is NULL.
+func exportRecordParse(record string) ([]sql.NullString, error) {
+
+	if len(record) < 2 || record[0] != '(' || record[len(record)-1] != ')' {
+		return nil, errors.New("Invalid record text")
+	}
+
+	var (
+		vals = []sql.NullString{}
+		val  = []byte{}
+		set  = false
+	)
+
+	for i := 1; i < len(record); i++ {
+
+		switch c := record[i]; c {
+
+		case ',', ')':
+			vals = append(vals, sql.NullString{String: string(val), Valid: set})
+			val, set = val[:0], false
+
+		case '"':
+			set = true
+			for i++; i < len(record); i++ {
+				if c = record[i]; c == '"' || c == '\\' {
+					if i+1 < len(record) && record[i+1] == c {
+						i++
+					} else if c == '"' {
+						break
+					}
+				}
+				val = append(val, c)
+			}
+
+		default:
+			set = true
+			val = append(val, c)
+		}
+	}
+
+	return vals, nil
+}
+
+// ExportTable writes all rows of table to w, see Export.
+func (dc *Dialect) ExportTable(w io.Writer, table string, opts *ExportOptions) (int64, error) {
+	return dc.Export(w, NewQueryer().From(dialectQuoteStr(table)), opts)
+}
+
+func (dc *Dialect) exportJSONLines(w io.Writer, query string, params []interface{}) (int64, error) {
+
+	rows, err := dc.IterRaw("SELECT row_to_json(pgsqlgo_export)::text FROM ("+query+") AS pgsqlgo_export", params...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var (
+		bw  = bufio.NewWriter(w)
+		num int64
+		js  []byte
+	)
+
+	for rows.Next() {
+		if err = rows.Scan(&js); err != nil {
+			return num, err
+		}
+		if _, err = bw.Write(append(js, '\n')); err != nil {
+			return num, err
+		}
+		num++
+	}
+
+	if err = rows.Err(); err != nil {
+		return num, err
+	}
+
+	return num, bw.Flush()
+}
+
+var exportTextReplacer = strings.NewReplacer(
+	"\\", "\\\\",
+	"\t", "\\t",
+	"\n", "\\n",
+	"\r", "\\r",
+)
+
+func exportTextValue(v sql.NullString) string {
+	if !v.Valid {
+		return "\\N"
+	}
+	return exportTextReplacer.Replace(v.String)
+}
+
+func exportCSVValue(v sql.NullString) string {
+	if !v.Valid {
+		return ""
+	}
+	if v.String == "" || v.String == "\\." || strings.ContainsAny(v.String, ",\"\r\n") {
+		return "\"" + strings.Replace(v.String, "\"", "\"\"", -1) + "\""
+	}
+	return v.String
+}
@@ -0,0 +1,133 @@
+// Copyright 2018 Eryx <evorui аt gmail dοt com>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgsqlgo
+
+import (
+	"strings"
+)
+
+// Expr is a SQL expression written into the statement as is, e.g. a window
+// function in Select or a computed sort key in Order. Its "?" placeholders are
+// bound to args in order. The SQL text must never be built from user input.
+type Expr struct {
+	sql  string
+	args []interface{}
+}
+
+// Raw returns an Expr, for example:
+//
+//	Raw("COUNT(*) OVER (PARTITION BY category_id)")
+//	Raw("DATE_TRUNC(?, created)", "day")
+func Raw(sql string, args ...interface{}) Expr {
+	return Expr{
+		sql:  sql,
+		args: args,
+	}
+}
+
+// Func returns a call of the SQL function name, its args are bound as
+// parameters, e.g. Func("nextval", "seq_user__id"). It is the strict
+// replacement of plain function strings like "nextval('seq_user__id')".
+func Func(name string, args ...interface{}) Expr {
+	if !exprIsIdent(name) {
+		name = dialectQuote + strings.Replace(name, dialectQuote, dialectQuote+dialectQuote, -1) + dialectQuote
+	}
+	if len(args) == 0 {
+		return Raw(name + "()")
+	}
+	return exprFunc(name+"(?)", args...)
+}
+
+func exprIsIdent(name string) bool {
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; !(c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// Col returns the quoted column name, e.g. to copy one column into another.
+func Col(name string) Expr {
+	return Raw(dialectQuoteStr(name))
+}
+
+// Now returns the current time of the transaction.
+func Now() Expr {
+	return Raw("now()")
+}
+
+// Incr adds delta to the column, e.g. Update(t, {"hits": Incr("hits", 1)}).
+func Incr(col string, delta interface{}) Expr {
+	return exprFunc(dialectQuoteStr(col)+" + ?", delta)
+}
+
+// Coalesce returns the first of args which is not NULL, args may be Expr
+// values like Col("name").
+func Coalesce(args ...interface{}) Expr {
+	return exprFunc("COALESCE(?)", args...)
+}
+
+// JsonbSet sets the value at path (e.g. "{a,b}") of the jsonb column, value
+// is given as json text.
+func JsonbSet(col, path string, value interface{}) Expr {
+	return exprFunc("jsonb_set("+dialectQuoteStr(col)+", ?::text[], ?::jsonb)", path, value)
+}
+
+// ArrayAppend appends value to the array column.
+func ArrayAppend(col string, value interface{}) Expr {
+	return exprFunc("array_append("+dialectQuoteStr(col)+", ?)", value)
+}
+
+// exprFunc renders args into the placeholders of sql, a single "?" takes
+// all remaining args as a comma separated list. Expr args are written as is.
+func exprFunc(sql string, args ...interface{}) Expr {
+
+	var (
+		parts  = strings.Split(sql, "?")
+		rs     = parts[0]
+		params []interface{}
+	)
+
+	for i := 1; i < len(parts); i++ {
+		vals := args
+		if i < len(parts)-1 {
+			vals, args = args[:1], args[1:]
+		}
+		list := make([]string, len(vals))
+		for j, v := range vals {
+			w, ps := filterArgParse(v)
+			list[j] = w
+			params = append(params, ps...)
+		}
+		rs += strings.Join(list, ", ") + parts[i]
+	}
+
+	return Expr{
+		sql:  rs,
+		args: params,
+	}
+}
+
+func (e Expr) String() string {
+	return e.sql
+}
+
+func (e Expr) Args() []interface{} {
+	return e.args
+}
@@ -34,12 +34,18 @@ var filterOperators = map[string]string{
 	"le":   "<= ?",
 	"like": "LIKE ?",
 	"in":   "IN (?)",
+	"null": "IS NULL",
+}
+
+var filterExistsOperators = map[string]string{
+	"exists":    "EXISTS (?)",
+	"notexists": "NOT EXISTS (?)",
 }
 
 type filterItem struct {
 	exprs    []string
 	args     []interface{}
-	filter   *Filter
+	filter   rdb.Filter
 	isOr     bool
 	isNot    bool
 	isFilter bool
@@ -117,39 +123,112 @@ func (fr *Filter) Parse() (where string, params []interface{}) {
 
 			params = append(params, ps...)
 
-		} else {
+		} else if op, ok := filterExistsOperators[p.exprs[0]]; ok && len(p.exprs) == 1 {
 
-			operator := ""
+			w, ps := filterArgParse(p.args[0])
+			where += filterOperatorFill(op, w) + " "
+			params = append(params, ps...)
 
-			if len(p.exprs) == 1 {
-				p.exprs = append(p.exprs, "eq")
-			}
+		} else {
 
-			if v, ok := filterOperators[p.exprs[1]]; ok {
-				operator = v
-			}
+			col, op := filterExprSplit(p.exprs)
 
-			if operator == "" {
+			operator, ok := filterOperators[op]
+			if !ok {
 				operator = "= ?"
 			}
 
-			if len(p.exprs) > 1 && p.exprs[1] == "in" && len(p.args) > 1 {
+			if op == "null" {
+
+				// "col.null", true or false
+				if v, ok := p.args[0].(bool); ok && !v {
+					where += dialectQuoteStr(col) + " IS NOT NULL "
+				} else {
+					where += dialectQuoteStr(col) + " IS NULL "
+				}
+
+			} else if op == "in" && len(p.args) > 1 {
 
 				res := []string{}
-				for i := 0; i < len(p.args); i++ {
-					res = append(res, "?")
+				for _, arg := range p.args {
+					w, ps := filterArgParse(arg)
+					res = append(res, w)
+					params = append(params, ps...)
 				}
 
-				where += fmt.Sprintf("%s IN (%s) ", dialectQuoteStr(p.exprs[0]), strings.Join(res, ","))
-				params = append(params, p.args...)
+				where += fmt.Sprintf("%s IN (%s) ", dialectQuoteStr(col), strings.Join(res, ","))
 
 			} else {
 
-				where += fmt.Sprintf("%s %s ", dialectQuoteStr(p.exprs[0]), operator)
-				params = append(params, p.args[0])
+				w, ps := filterArgParse(p.args[0])
+				where += fmt.Sprintf("%s %s ", dialectQuoteStr(col), filterOperatorFill(operator, w))
+				params = append(params, ps...)
 			}
 		}
 	}
 
 	return
 }
+
+// filterExprSplit returns the column and the operator of an expression like
+// "id", "id.gt", or with a table qualifier "t.id" and "t.id.gt".
+func filterExprSplit(exprs []string) (col, op string) {
+
+	switch n := len(exprs); {
+
+	case n == 1:
+		return exprs[0], "eq"
+
+	case n == 2:
+		if _, ok := filterOperators[exprs[1]]; ok {
+			return exprs[0], exprs[1]
+		}
+		return exprs[0] + filterExprSep + exprs[1], "eq"
+	}
+
+	n := len(exprs) - 1
+	return strings.Join(exprs[:n], filterExprSep), exprs[n]
+}
+
+// filterArgParse renders one filter argument. A Queryer is expanded in place
+// into its SELECT statement, without a LIMIT unless one is set by Limit, and
+// an Expr is written as is, so their placeholders and params keep their
+// order relative to the rest of the filter; any other value is bound as "?".
+//
+// Select quotes its columns, so use SelectExpr(Raw("1")) for the usual
+// "SELECT 1" of an EXISTS subquery.
+func filterArgParse(arg interface{}) (string, []interface{}) {
+	switch v := arg.(type) {
+	case rdb.Queryer:
+		w, ps := querySubParse(v)
+		return "(" + w + ")", ps
+	case Expr:
+		return v.sql, v.args
+	}
+	return "?", []interface{}{arg}
+}
+
+// filterOperatorFill puts the rendered argument w into the placeholder of
+// the operator, a parenthesized subquery replaces the parentheses of
+// operators like "IN (?)".
+func filterOperatorFill(operator, w string) string {
+	if strings.HasPrefix(w, "(") && strings.Contains(operator, "(?)") {
+		return strings.Replace(operator, "(?)", w, 1)
+	}
+	return strings.Replace(operator, "?", w, 1)
+}
+
+// filterNest returns a new Filter with fr as its first, parenthesized
+// condition, so that more conditions can be added with AND.
+func filterNest(fr rdb.Filter) *Filter {
+	nfr := &Filter{}
+	if fr != nil {
+		if w, _ := fr.Parse(); strings.TrimSpace(w) != "" {
+			nfr.params = append(nfr.params, filterItem{
+				filter:   fr,
+				isFilter: true,
+			})
+		}
+	}
+	return nfr
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 Eryx <evorui аt gmail dοt com>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgsqlgo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFilterSubquery(t *testing.T) {
+
+	items := NewQueryer().Select("user_id").From("items").(*Queryer)
+	items.Where().And("items.user_id", Col("users.id"))
+
+	tests := []struct {
+		filter *Filter
+		want   string
+		params []interface{}
+	}{
+		{
+			filter: NewFilter().And("id.in", NewQueryer().Select("id").From("users")).(*Filter),
+			want:   `"id" IN (SELECT "id" FROM users)`,
+		},
+		{
+			filter: NewFilter().And("id.in", NewQueryer().Select("id").From("users").Limit(10)).(*Filter),
+			want:   `"id" IN (SELECT "id" FROM users LIMIT ?)`,
+			params: []interface{}{int64(10)},
+		},
+		{
+			filter: NewFilter().And("exists", items).(*Filter),
+			want:   `EXISTS (SELECT "user_id" FROM items WHERE "items"."user_id" = "users"."id")`,
+		},
+		{
+			filter: NewFilter().And("notexists", items).(*Filter),
+			want:   `NOT EXISTS (SELECT "user_id" FROM items WHERE "items"."user_id" = "users"."id")`,
+		},
+		{
+			filter: NewFilter().And("status", 1).And("score.gt",
+				NewQueryer().(*Queryer).SelectExpr(Raw("avg(score)")).From("users")).(*Filter),
+			want:   `"status" = ? AND "score" > (SELECT avg(score) FROM users)`,
+			params: []interface{}{1},
+		},
+	}
+
+	for _, v := range tests {
+		w, ps := filterParse(v.filter)
+		if w != v.want || !reflect.DeepEqual(ps, v.params) {
+			t.Errorf("got %s %v\nwant %s %v", w, ps, v.want, v.params)
+		}
+	}
+}
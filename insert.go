@@ -0,0 +1,284 @@
+// Copyright 2018 Eryx <evorui аt gmail dοt com>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgsqlgo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// PostgreSQL accepts at most 65535 bind parameters per statement
+	insertBatchParamsMax = 65535
+)
+
+// Upsert describes the ON CONFLICT clause of Dialect.Upsert.
+type Upsert struct {
+	// Conflict is the list of columns of the unique index which detects
+	// the conflict, Constraint names the constraint instead.
+	Conflict   []string
+	Constraint string
+
+	// Update is the list of columns set from the EXCLUDED row, all inserted
+	// columns except the Conflict ones if empty.
+	Update []string
+
+	// Where optionally restricts the rows which are updated, e.g.
+	// Raw(`"t"."updated" < EXCLUDED."updated"`) for table t.
+	Where *Expr
+
+	// DoNothing skips conflicting rows instead of updating them, the
+	// conflict target is optional then.
+	DoNothing bool
+}
+
+// Upsert inserts item into table, or updates the existing row on conflict
+// (INSERT ... ON CONFLICT DO UPDATE, >= v9.5).
+func (dc *Dialect) Upsert(table string, item map[string]interface{}, opts *Upsert) (sql.Result, error) {
+
+	query, params, err := upsertParse(table, item, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	query, params, err = dialectStmtBind(query, params, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return dc.exec(query, params)
+}
+
+func upsertParse(table string, item map[string]interface{}, opts *Upsert) (string, []interface{}, error) {
+
+	if err := upsertValid(opts); err != nil {
+		return "", nil, err
+	}
+
+	cols := insertColumns(item)
+
+	query, params, err := insertParse(table, cols, []map[string]interface{}{item})
+	if err != nil {
+		return "", nil, err
+	}
+
+	conflict, ps := upsertConflictParse(cols, opts)
+
+	return query + " " + conflict, append(params, ps...), nil
+}
+
+func upsertValid(opts *Upsert) error {
+	if opts == nil || (len(opts.Conflict) == 0 && opts.Constraint == "" && !opts.DoNothing) {
+		return errors.New("Upsert requires conflict columns or a constraint")
+	}
+	return nil
+}
+
+func upsertConflictParse(cols []string, opts *Upsert) (string, []interface{}) {
+
+	var (
+		sql     = "ON CONFLICT "
+		params  []interface{}
+		updates = opts.Update
+	)
+
+	if opts.Constraint != "" {
+		sql += "ON CONSTRAINT " + dialectQuoteStr(opts.Constraint) + " "
+	} else if len(opts.Conflict) > 0 {
+		conflict := make([]string, len(opts.Conflict))
+		for i, v := range opts.Conflict {
+			conflict[i] = dialectQuoteStr(v)
+		}
+		sql += "(" + strings.Join(conflict, ",") + ") "
+	}
+
+	if len(updates) == 0 {
+		skip := map[string]bool{}
+		for _, v := range opts.Conflict {
+			skip[v] = true
+		}
+		for _, v := range cols {
+			if !skip[v] {
+				updates = append(updates, v)
+			}
+		}
+	}
+
+	if len(updates) == 0 || opts.DoNothing {
+		return sql + "DO NOTHING", nil
+	}
+
+	sets := make([]string, len(updates))
+	for i, v := range updates {
+		sets[i] = fmt.Sprintf("%s = EXCLUDED.%s", dialectQuoteStr(v), dialectQuoteStr(v))
+	}
+	sql += "DO UPDATE SET " + strings.Join(sets, ",")
+
+	if opts.Where != nil {
+		sql += " WHERE " + opts.Where.sql
+		params = append(params, opts.Where.args...)
+	}
+
+	return sql, params
+}
+
+// insertColumns returns the sorted column names of all items.
+func insertColumns(items ...map[string]interface{}) []string {
+	var (
+		cols = []string{}
+		seen = map[string]bool{}
+	)
+	for _, item := range items {
+		for k := range item {
+			if !seen[k] {
+				seen[k] = true
+				cols = append(cols, k)
+			}
+		}
+	}
+	sort.Strings(cols)
+	return cols
+}
+
+// insertParse renders a (multi-row) INSERT statement of the given columns,
+// an Expr value is written as is, any other value is bound as "?".
+func insertParse(table string, cols []string, items []map[string]interface{}) (string, []interface{}, error) {
+
+	if len(cols) == 0 || len(items) == 0 {
+		return "", nil, errors.New("No columns to insert")
+	}
+
+	var (
+		names  = make([]string, len(cols))
+		rows   = make([]string, len(items))
+		params []interface{}
+	)
+
+	for i, v := range cols {
+		names[i] = dialectQuoteStr(v)
+	}
+
+	for i, item := range items {
+		vals := make([]string, len(cols))
+		for j, col := range cols {
+			v, ok := item[col]
+			if !ok {
+				vals[j] = "DEFAULT"
+				continue
+			}
+			w, ps := filterArgParse(v)
+			vals[j] = w
+			params = append(params, ps...)
+		}
+		rows[i] = "(" + strings.Join(vals, ",") + ")"
+	}
+
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES %s",
+		dialectQuoteStr(table), strings.Join(names, ","), strings.Join(rows, ",")), params, nil
+}
+
+// InsertBatch inserts items into table with multi-row INSERT statements in
+// one transaction. The statements are split into chunks which stay under
+// the bind parameter limit of PostgreSQL, the number of rows inserted by
+// each chunk is returned. Columns missing in an item are set to DEFAULT.
+// If opts is not nil, the ON CONFLICT clause of Upsert is added.
+func (dc *Dialect) InsertBatch(table string, items []map[string]interface{}, opts *Upsert) ([]int64, error) {
+
+	tx, err := dc.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := tx.InsertBatch(table, items, opts)
+	if err != nil {
+		tx.Rollback()
+		return rs, err
+	}
+
+	return rs, tx.Commit()
+}
+
+// InsertBatch is like Dialect.InsertBatch, inside the transaction.
+func (tx *Tx) InsertBatch(table string, items []map[string]interface{}, opts *Upsert) ([]int64, error) {
+
+	if opts != nil {
+		if err := upsertValid(opts); err != nil {
+			return nil, err
+		}
+	}
+
+	var (
+		cols       = insertColumns(items...)
+		rs         = []int64{}
+		conflict   string
+		conflictPs []interface{}
+	)
+
+	if opts != nil {
+		conflict, conflictPs = upsertConflictParse(cols, opts)
+	}
+
+	for offset := 0; offset < len(items); {
+
+		var (
+			num    = 0
+			params = len(conflictPs)
+		)
+		for _, item := range items[offset:] {
+			n := insertParamsNum(item)
+			if num > 0 && params+n > insertBatchParamsMax {
+				break
+			}
+			num++
+			params += n
+		}
+
+		query, ps, err := insertParse(table, cols, items[offset:offset+num])
+		if err != nil {
+			return rs, err
+		}
+		if conflict != "" {
+			query += " " + conflict
+			ps = append(ps, conflictPs...)
+		}
+
+		res, err := tx.execUncached(query, ps)
+		if err != nil {
+			return rs, fmt.Errorf("Insert rows %d to %d: %s", offset, offset+num-1, err.Error())
+		}
+
+		n, _ := res.RowsAffected()
+		rs = append(rs, n)
+
+		offset += num
+	}
+
+	return rs, nil
+}
+
+func insertParamsNum(item map[string]interface{}) int {
+	num := 0
+	for _, v := range item {
+		if e, ok := v.(Expr); ok {
+			num += len(e.args)
+		} else {
+			num++
+		}
+	}
+	return num
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 Eryx <evorui аt gmail dοt com>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgsqlgo
+
+import (
+	"database/sql"
+)
+
+// ExecNamed runs a statement with :name or @name parameters bound from arg,
+// a map[string]interface{} or a struct with `db` tags, e.g.
+//
+//	dc.ExecNamed("UPDATE users SET name = :name WHERE id = :id", user)
+//
+// A repeated name is bound once, and "::type" casts are left as is.
+func (dc *Dialect) ExecNamed(query string, arg interface{}) (sql.Result, error) {
+	query, args, err := dialectStmtBindNamed(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return dc.exec(query, args)
+}
+
+// QueryNamed runs a query with named parameters, see ExecNamed, and returns
+// its result as a stream of rows.
+func (dc *Dialect) QueryNamed(query string, arg interface{}) (*Rows, error) {
+	query, args, err := dialectStmtBindNamed(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := dc.query(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &Rows{rows}, nil
+}
+
+func (tx *Tx) ExecNamed(query string, arg interface{}) (sql.Result, error) {
+	query, args, err := dialectStmtBindNamed(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return tx.exec(query, args)
+}
+
+func (tx *Tx) QueryNamed(query string, arg interface{}) (*Rows, error) {
+	query, args, err := dialectStmtBindNamed(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return tx.query(query, args)
+}
@@ -0,0 +1,158 @@
+// Copyright 2018 Eryx <evorui аt gmail dοt com>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgsqlgo
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/lynkdb/iomix/rdb"
+)
+
+const (
+	pageTotalField = "pgsqlgo_total"
+)
+
+type Page struct {
+	Items    []*rdb.Entry
+	Total    int64
+	Page     int64
+	PageSize int64
+}
+
+// QueryPage returns the given page (starting at 1) of the query result and
+// the total number of matching rows, which is counted by a second query
+// derived from q without its ORDER BY, LIMIT and OFFSET clauses. It sets
+// Limit and Offset of q to the page. Both queries bind strictly, so that
+// they always agree on the values.
+func (dc *Dialect) QueryPage(q rdb.Queryer, page, pageSize int64) (*Page, error) {
+
+	rs, err := pageInit(q, page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if rs.Items, err = dc.pageQuery(q); err != nil {
+		return nil, err
+	}
+
+	if rs.Total, err = dc.pageCount(q); err != nil {
+		return nil, err
+	}
+
+	return rs, nil
+}
+
+// QueryPageWindow is like QueryPage, but reads the total number in the same
+// round trip through "COUNT(*) OVER ()". A second query is only run when the
+// page is past the end of the result, or q is a UNION, INTERSECT, EXCEPT or
+// DISTINCT query, whose rows the window function would count before they
+// are combined or de-duplicated.
+func (dc *Dialect) QueryPageWindow(q *Queryer, page, pageSize int64) (*Page, error) {
+
+	if len(q.sets) > 0 || q.distinct {
+		return dc.QueryPage(q, page, pageSize)
+	}
+
+	rs, err := pageInit(q, page, pageSize)
+	if err != nil {
+		return nil, err
+	}
+
+	qc := *q
+	if qc.cols == "" {
+		qc.cols = "*"
+	}
+	qc.exprs = append(append([]Expr{}, q.exprs...), Raw("COUNT(*) OVER () AS "+pageTotalField))
+
+	if rs.Items, err = dc.pageQuery(&qc); err != nil {
+		return nil, err
+	}
+
+	if len(rs.Items) == 0 {
+		if rs.Page > 1 {
+			if rs.Total, err = dc.pageCount(q); err != nil {
+				return nil, err
+			}
+		}
+		return rs, nil
+	}
+
+	for _, entry := range rs.Items {
+		if v, ok := entry.Fields[pageTotalField]; ok {
+			if rs.Total == 0 {
+				rs.Total, _ = strconv.ParseInt(v.String(), 10, 64)
+			}
+			delete(entry.Fields, pageTotalField)
+		}
+	}
+
+	return rs, nil
+}
+
+func pageInit(q rdb.Queryer, page, pageSize int64) (*Page, error) {
+
+	if pageSize < 1 {
+		return nil, errors.New("Invalid Page Size")
+	}
+
+	if page < 1 {
+		page = 1
+	}
+
+	q.Limit(pageSize).Offset((page - 1) * pageSize)
+
+	return &Page{
+		Page:     page,
+		PageSize: pageSize,
+	}, nil
+}
+
+// pageQuery is Query with strict binding, like pageCount.
+func (dc *Dialect) pageQuery(q rdb.Queryer) ([]*rdb.Entry, error) {
+	if err := queryCheck(q, false); err != nil {
+		return nil, err
+	}
+	query, params := dc.softDeleteScope(q).Parse()
+	return dc.queryRawStrict(query, params...)
+}
+
+func (dc *Dialect) pageCount(q rdb.Queryer) (int64, error) {
+
+	qr, ok := dc.softDeleteScope(q).(*Queryer)
+	if !ok {
+		return 0, errors.New("Unsupported Queryer for counting")
+	}
+
+	qc := *qr
+	qc.order, qc.orders = "", nil
+	sql, params := qc.parseBody()
+
+	rs, err := dc.queryRawStrict("SELECT COUNT(*) AS "+pageTotalField+" FROM ("+sql+") AS pgsqlgo_page", params...)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(rs) == 0 {
+		return 0, nil
+	}
+
+	v, ok := rs[0].Fields[pageTotalField]
+	if !ok {
+		return 0, nil
+	}
+
+	return strconv.ParseInt(v.String(), 10, 64)
+}
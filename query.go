@@ -15,25 +15,73 @@
 package pgsqlgo
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/lynkdb/iomix/rdb"
 )
 
+const (
+	queryMaterializedDefault = iota
+	queryMaterialized
+	queryNotMaterialized
+)
+
+const (
+	queryLockNone = iota
+	queryLockUpdate
+	queryLockNoKeyUpdate
+	queryLockShare
+	queryLockKeyShare
+)
+
+var queryLockModes = map[int]string{
+	queryLockUpdate:      "FOR UPDATE",
+	queryLockNoKeyUpdate: "FOR NO KEY UPDATE",
+	queryLockShare:       "FOR SHARE",
+	queryLockKeyShare:    "FOR KEY SHARE",
+}
+
+type queryCTE struct {
+	name         string
+	query        rdb.Queryer
+	recursive    rdb.Queryer
+	union        string
+	materialized int
+}
+
+// queryNoLimit removes the LIMIT clause, see Queryer.NoLimit
+const queryNoLimit int64 = -1
+
+type querySet struct {
+	op    string
+	query rdb.Queryer
+}
+
 type Queryer struct {
-	cols   string
-	table  string
-	order  string
-	group  string
-	limit  int64
-	offset int64
-	where  rdb.Filter
+	ctes       []queryCTE
+	distinct   bool
+	distinctOn []string
+	cols       string
+	exprs      []Expr
+	table      string
+	order      string
+	orders     []Expr
+	group      string
+	limit      int64
+	limitSet   bool
+	offset     int64
+	where      rdb.Filter
+	lock       int
+	lockOf     []string
+	lockOpt    string
+	sets       []querySet
+	deleted    bool
 }
 
 func NewQueryer() rdb.Queryer {
 	return &Queryer{
-		cols:   "*",
 		limit:  1,
 		offset: 0,
 	}
@@ -44,6 +92,29 @@ func (q *Queryer) Select(s string) rdb.Queryer {
 	return q
 }
 
+// SelectExpr appends expressions to the select list, they are written as is,
+// after the columns given by Select.
+func (q *Queryer) SelectExpr(exprs ...Expr) *Queryer {
+	q.exprs = append(q.exprs, exprs...)
+	return q
+}
+
+// Distinct removes duplicate rows from the result.
+func (q *Queryer) Distinct() *Queryer {
+	q.distinct = true
+	q.distinctOn = nil
+	return q
+}
+
+// DistinctOn keeps only the first row of each set of rows where the given
+// columns are equal, e.g. the latest row per group. The columns must lead
+// the ORDER BY clause.
+func (q *Queryer) DistinctOn(cols ...string) *Queryer {
+	q.distinct = true
+	q.distinctOn = cols
+	return q
+}
+
 func (q *Queryer) From(s string) rdb.Queryer {
 	q.table = s
 	return q
@@ -54,6 +125,13 @@ func (q *Queryer) Order(s string) rdb.Queryer {
 	return q
 }
 
+// OrderExpr appends expressions to the ORDER BY clause, after the one given
+// by Order.
+func (q *Queryer) OrderExpr(exprs ...Expr) *Queryer {
+	q.orders = append(q.orders, exprs...)
+	return q
+}
+
 func (q *Queryer) Group(s string) rdb.Queryer {
 	q.group = s
 	return q
@@ -61,6 +139,15 @@ func (q *Queryer) Group(s string) rdb.Queryer {
 
 func (q *Queryer) Limit(num int64) rdb.Queryer {
 	q.limit = num
+	q.limitSet = true
+	return q
+}
+
+// NoLimit removes the LIMIT clause so that all matching rows are returned.
+// Use Dialect.Iter to stream large results instead of loading them at once.
+func (q *Queryer) NoLimit() *Queryer {
+	q.limit = queryNoLimit
+	q.limitSet = true
 	return q
 }
 
@@ -69,42 +156,372 @@ func (q *Queryer) Offset(num int64) rdb.Queryer {
 	return q
 }
 
+// With adds a common table expression that can be referenced by name in From.
+// The name may carry a column list, e.g. "tree(id, pid)".
+func (q *Queryer) With(name string, sq rdb.Queryer) *Queryer {
+	q.ctes = append(q.ctes, queryCTE{
+		name:  name,
+		query: sq,
+	})
+	return q
+}
+
+// WithMaterialized is like With, but adds a MATERIALIZED or
+// NOT MATERIALIZED hint (PostgreSQL >= v12).
+func (q *Queryer) WithMaterialized(name string, sq rdb.Queryer, materialized bool) *Queryer {
+	cte := queryCTE{
+		name:         name,
+		query:        sq,
+		materialized: queryNotMaterialized,
+	}
+	if materialized {
+		cte.materialized = queryMaterialized
+	}
+	q.ctes = append(q.ctes, cte)
+	return q
+}
+
+// WithRecursive adds a recursive common table expression in the form of
+// "anchor UNION ALL recursive". PostgreSQL does not allow ORDER BY or LIMIT
+// in the recursive term, so Order, Limit and Offset of the recursive
+// Queryer are omitted.
+func (q *Queryer) WithRecursive(name string, anchor, recursive rdb.Queryer) *Queryer {
+	return q.WithRecursiveUnion(name, anchor, recursive, true)
+}
+
+// WithRecursiveUnion is like WithRecursive, but joins the terms with UNION
+// instead of UNION ALL if all is false, which discards duplicate rows and
+// so ends the recursion on cyclic data.
+func (q *Queryer) WithRecursiveUnion(name string, anchor, recursive rdb.Queryer, all bool) *Queryer {
+	cte := queryCTE{
+		name:      name,
+		query:     anchor,
+		recursive: recursive,
+		union:     "UNION",
+	}
+	if all {
+		cte.union = "UNION ALL"
+	}
+	q.ctes = append(q.ctes, cte)
+	return q
+}
+
+// Union combines the result of the Queryer with the results of qs, removing
+// duplicate rows. Once combined, Order, Limit and Offset of the Queryer
+// apply to the whole result, while those of qs apply to their own part,
+// where only a limit set by Limit is written.
+func (q *Queryer) Union(qs ...rdb.Queryer) *Queryer {
+	return q.addSet("UNION", qs)
+}
+
+func (q *Queryer) UnionAll(qs ...rdb.Queryer) *Queryer {
+	return q.addSet("UNION ALL", qs)
+}
+
+func (q *Queryer) Intersect(qs ...rdb.Queryer) *Queryer {
+	return q.addSet("INTERSECT", qs)
+}
+
+func (q *Queryer) Except(qs ...rdb.Queryer) *Queryer {
+	return q.addSet("EXCEPT", qs)
+}
+
+func (q *Queryer) addSet(op string, qs []rdb.Queryer) *Queryer {
+	for _, v := range qs {
+		q.sets = append(q.sets, querySet{
+			op:    op,
+			query: v,
+		})
+	}
+	return q
+}
+
+// ForUpdate locks the selected rows, of the given tables only if any.
+// A locking Queryer can only be run inside a transaction, see Dialect.Begin.
+func (q *Queryer) ForUpdate(of ...string) *Queryer {
+	return q.setLock(queryLockUpdate, of)
+}
+
+func (q *Queryer) ForNoKeyUpdate(of ...string) *Queryer {
+	return q.setLock(queryLockNoKeyUpdate, of)
+}
+
+func (q *Queryer) ForShare(of ...string) *Queryer {
+	return q.setLock(queryLockShare, of)
+}
+
+func (q *Queryer) ForKeyShare(of ...string) *Queryer {
+	return q.setLock(queryLockKeyShare, of)
+}
+
+// NoWait makes the locking clause report an error instead of waiting
+// for rows locked by other transactions.
+func (q *Queryer) NoWait() *Queryer {
+	q.lockOpt = "NOWAIT"
+	return q
+}
+
+// SkipLocked makes the locking clause skip rows locked by other
+// transactions, e.g. to take jobs from a queue table.
+func (q *Queryer) SkipLocked() *Queryer {
+	q.lockOpt = "SKIP LOCKED"
+	return q
+}
+
+func (q *Queryer) setLock(mode int, of []string) *Queryer {
+	q.lock = mode
+	q.lockOf = of
+	return q
+}
+
+// Valid reports the errors which would otherwise only be detected by the
+// server, e.g. DISTINCT ON columns that do not lead the ORDER BY clause.
+func (q *Queryer) Valid() error {
+
+	if q.Locked() && len(q.sets) > 0 {
+		return errors.New("Row locking clauses are not allowed with UNION, INTERSECT or EXCEPT")
+	}
+
+	if len(q.distinctOn) == 0 {
+		return nil
+	}
+
+	orders := []string{}
+	for _, v := range strings.Split(q.order, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			orders = append(orders, v)
+		}
+	}
+	for _, v := range q.orders {
+		orders = append(orders, v.sql)
+	}
+
+	if len(orders) == 0 {
+		return nil
+	}
+
+	if len(orders) < len(q.distinctOn) {
+		return errors.New("DISTINCT ON columns must lead the ORDER BY clause")
+	}
+
+	leads := map[string]bool{}
+	for _, v := range orders[:len(q.distinctOn)] {
+		if fs := strings.Fields(v); len(fs) > 0 {
+			leads[queryColumnKey(fs[0])] = true
+		}
+	}
+
+	for _, v := range q.distinctOn {
+		if !leads[queryColumnKey(v)] {
+			return fmt.Errorf("DISTINCT ON column %s must lead the ORDER BY clause", v)
+		}
+	}
+
+	return nil
+}
+
+func queryColumnKey(name string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(name), dialectQuote, "", -1))
+}
+
+// IncludeDeleted also returns the rows of a soft-delete table which are
+// marked as deleted, see Dialect.SoftDelete.
+func (q *Queryer) IncludeDeleted() *Queryer {
+	q.deleted = true
+	return q
+}
+
+// Locked reports whether the Queryer has a locking clause.
+func (q *Queryer) Locked() bool {
+	return q.lock != queryLockNone
+}
+
 func (q *Queryer) Parse() (sql string, params []interface{}) {
+	return q.parse(true)
+}
+
+// parse renders the statement, the default LIMIT of NewQueryer is only
+// written if withLimit is set, otherwise only a limit set by Limit is.
+func (q *Queryer) parse(withLimit bool) (sql string, params []interface{}) {
+
+	sql, params = q.parseBody()
+
+	if q.offset > 0 {
+		sql += "OFFSET ? "
+		params = append(params, q.offset)
+	}
+
+	if q.limit >= 0 && (withLimit || q.limitSet) {
+		sql += "LIMIT ?"
+		params = append(params, q.limit)
+	}
+
+	sql = strings.TrimSpace(sql)
+
+	if mode, ok := queryLockModes[q.lock]; ok {
+		sql += " " + mode
+		if len(q.lockOf) > 0 {
+			of := make([]string, len(q.lockOf))
+			for i, v := range q.lockOf {
+				of[i] = dialectQuoteStr(v)
+			}
+			sql += " OF " + strings.Join(of, ",")
+		}
+		if q.lockOpt != "" {
+			sql += " " + q.lockOpt
+		}
+	}
+
+	return
+}
+
+func (q *Queryer) parseWith() (sql string, params []interface{}) {
+
+	if len(q.ctes) == 0 {
+		return
+	}
+
+	var (
+		parts     = []string{}
+		recursive = false
+	)
+
+	for _, cte := range q.ctes {
+
+		part := cte.name + " AS "
+
+		switch cte.materialized {
+		case queryMaterialized:
+			part += "MATERIALIZED "
+		case queryNotMaterialized:
+			part += "NOT MATERIALIZED "
+		}
+
+		w, ps := querySubParse(cte.query)
+		params = append(params, ps...)
+
+		if cte.recursive != nil {
+			recursive = true
+			rw, rps := queryRecursiveParse(cte.recursive)
+			part += fmt.Sprintf("((%s) %s %s)", w, cte.union, rw)
+			params = append(params, rps...)
+		} else {
+			part += fmt.Sprintf("(%s)", w)
+		}
+
+		parts = append(parts, part)
+	}
+
+	sql = "WITH "
+	if recursive {
+		sql += "RECURSIVE "
+	}
+	sql += strings.Join(parts, ", ") + " "
+
+	return
+}
+
+// parseBody renders the statement without the LIMIT and OFFSET clauses.
+func (q *Queryer) parseBody() (sql string, params []interface{}) {
+
+	sql, params = q.parseWith()
+
+	w, ps := q.parseSelect()
+	params = append(params, ps...)
+
+	if len(q.sets) > 0 {
+
+		sql += "(" + strings.TrimSpace(w) + ") "
+
+		for _, set := range q.sets {
+			w, ps = querySubParse(set.query)
+			sql += set.op + " (" + strings.TrimSpace(w) + ") "
+			params = append(params, ps...)
+		}
+
+	} else {
+		sql += w
+	}
+
+	orders := []string{}
+	if len(q.order) > 0 {
+		orders = append(orders, q.order)
+	}
+	for _, v := range q.orders {
+		orders = append(orders, v.sql)
+		params = append(params, v.args...)
+	}
+	if len(orders) > 0 {
+		sql += "ORDER BY " + strings.Join(orders, ",") + " "
+	}
 
-	cols := strings.Split(q.cols, ",")
-	for i, v := range cols {
-		cols[i] = dialectQuoteStr(v)
+	return
+}
+
+func (q *Queryer) parseSelect() (sql string, params []interface{}) {
+
+	cols := []string{}
+	if q.cols != "" {
+		cols = strings.Split(q.cols, ",")
+		for i, v := range cols {
+			cols[i] = dialectQuoteStr(v)
+		}
+	}
+	for _, v := range q.exprs {
+		cols = append(cols, v.sql)
+		params = append(params, v.args...)
+	}
+	if len(cols) == 0 {
+		cols = append(cols, "*")
 	}
 
-	sql = fmt.Sprintf("SELECT %s ", strings.Join(cols, ","))
+	sql = "SELECT "
+	if len(q.distinctOn) > 0 {
+		on := make([]string, len(q.distinctOn))
+		for i, v := range q.distinctOn {
+			on[i] = dialectQuoteStr(strings.TrimSpace(v))
+		}
+		sql += fmt.Sprintf("DISTINCT ON (%s) ", strings.Join(on, ","))
+	} else if q.distinct {
+		sql += "DISTINCT "
+	}
+	sql += strings.Join(cols, ",") + " "
 
 	if q.table != "" {
 		sql += fmt.Sprintf("FROM %s ", q.table)
 	}
 
 	frsql, ps := q.Where().Parse()
-	if len(ps) > 0 {
+	if strings.TrimSpace(frsql) != "" {
 		sql += "WHERE " + frsql + " "
-		params = ps
-	}
-
-	if len(q.order) > 0 {
-		sql += "ORDER BY " + q.order + " "
+		params = append(params, ps...)
 	}
 
 	if len(q.group) > 0 {
 		sql += "GROUP BY " + q.group + " "
 	}
 
-	if q.offset > 0 {
-		sql += "OFFSET ? LIMIT ?"
-		params = append(params, q.offset, q.limit)
-	} else {
-		sql += "LIMIT ?"
-		params = append(params, q.limit)
+	return
+}
+
+// querySubParse renders q as a subquery, without the default LIMIT of
+// NewQueryer, which would silently cut the subquery to one row.
+func querySubParse(q rdb.Queryer) (string, []interface{}) {
+	if qr, ok := q.(*Queryer); ok {
+		return qr.parse(false)
 	}
+	return q.Parse()
+}
 
-	return
+// queryRecursiveParse renders the recursive term of a CTE, without the
+// ORDER BY, LIMIT and OFFSET clauses.
+func queryRecursiveParse(q rdb.Queryer) (string, []interface{}) {
+	if qr, ok := q.(*Queryer); ok {
+		qc := *qr
+		qc.order, qc.orders = "", nil
+		return qc.parseBody()
+	}
+	return q.Parse()
 }
 
 func (q *Queryer) Where() rdb.Filter {
@@ -0,0 +1,114 @@
+// Copyright 2018 Eryx <evorui аt gmail dοt com>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgsqlgo
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lynkdb/iomix/rdb"
+)
+
+// Rows streams the result of a query row by row instead of loading it into
+// a []*rdb.Entry. The caller must Close it, or read it until Next returns
+// false, and then check Err.
+type Rows struct {
+	*sql.Rows
+}
+
+// Iter runs the query and returns its result as a stream of rows.
+func (dc *Dialect) Iter(q rdb.Queryer) (*Rows, error) {
+	if err := queryCheck(q, false); err != nil {
+		return nil, err
+	}
+	query, args := dc.softDeleteScope(q).Parse()
+	return dc.IterRaw(query, args...)
+}
+
+// queryRawStrict is QueryRaw with strict binding.
+func (dc *Dialect) queryRawStrict(query string, args ...interface{}) ([]*rdb.Entry, error) {
+	rows, err := dc.IterRaw(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return rows.entries(dc.TypeDatetimeFmt)
+}
+
+func (dc *Dialect) IterRaw(query string, args ...interface{}) (*Rows, error) {
+	query, args, err := dialectStmtBind(query, args, true)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := dc.query(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &Rows{rows}, nil
+}
+
+// entries reads all rows into entries like the QueryRaw of rdb.Base, a
+// value is kept as its text, times are formatted by datetimeFmt and NULL
+// columns are left out. The rows are closed.
+func (rs *Rows) entries(datetimeFmt string) ([]*rdb.Entry, error) {
+
+	defer rs.Close()
+
+	cols, err := rs.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		entries = []*rdb.Entry{}
+		values  = make([]interface{}, len(cols))
+		scans   = make([]interface{}, len(cols))
+	)
+	for i := range values {
+		scans[i] = &values[i]
+	}
+
+	for rs.Next() {
+
+		if err = rs.Scan(scans...); err != nil {
+			return nil, err
+		}
+
+		entry := &rdb.Entry{
+			Fields: map[string]*rdb.Bytex{},
+		}
+		for i, v := range values {
+
+			var bs []byte
+			switch v := v.(type) {
+			case nil:
+				continue
+			case []byte:
+				bs = v
+			case time.Time:
+				bs = []byte(v.Format(datetimeFmt))
+			default:
+				bs = []byte(fmt.Sprint(v))
+			}
+
+			field := rdb.Bytex(bs)
+			entry.Fields[cols[i]] = &field
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rs.Err()
+}
@@ -0,0 +1,240 @@
+// Copyright 2018 Eryx <evorui аt gmail dοt com>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgsqlgo
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/lynkdb/iomix/rdb"
+)
+
+// SoftDelete turns on the soft-delete mode of table: Delete sets the
+// timestamp column (e.g. "deleted_at") to now() instead of removing the
+// rows, and Query, Fetch, Iter and Count only return the rows where the
+// column is NULL, unless Queryer.IncludeDeleted is set. An empty column
+// turns the mode off.
+func (dc *Dialect) SoftDelete(table, column string) {
+	dc.softMu.Lock()
+	defer dc.softMu.Unlock()
+	if dc.softDeletes == nil {
+		dc.softDeletes = map[string]string{}
+	}
+	if column == "" {
+		delete(dc.softDeletes, table)
+	} else {
+		dc.softDeletes[table] = column
+	}
+}
+
+// softDeleteColumn returns the soft-delete column of table, a table
+// qualified by its schema, e.g. "public.users", also takes the mode of
+// its unqualified name.
+func (dc *Dialect) softDeleteColumn(table string) string {
+	table = strings.Replace(table, dialectQuote, "", -1)
+	dc.softMu.RLock()
+	defer dc.softMu.RUnlock()
+	if col, ok := dc.softDeletes[table]; ok {
+		return col
+	}
+	if n := strings.LastIndexByte(table, '.'); n > 0 {
+		return dc.softDeletes[table[n+1:]]
+	}
+	return ""
+}
+
+// Delete removes the rows matched by fr, or marks them as deleted if the
+// table is in soft-delete mode.
+func (dc *Dialect) Delete(table string, fr rdb.Filter) (sql.Result, error) {
+
+	col := dc.softDeleteColumn(table)
+	if col == "" {
+		return dc.Base.Delete(table, fr)
+	}
+
+	query, params, err := updateParse(table, map[string]interface{}{
+		col: Now(),
+	}, softDeleteFilter(fr, col))
+	if err != nil {
+		return nil, err
+	}
+
+	query, params, err = dialectStmtBind(query, params, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return dc.exec(query, params)
+}
+
+// HardDelete removes the rows matched by fr, also from a table in
+// soft-delete mode.
+func (dc *Dialect) HardDelete(table string, fr rdb.Filter) (sql.Result, error) {
+	return dc.Base.Delete(table, fr)
+}
+
+// Count returns the number of rows matched by fr, without the rows marked
+// as deleted if the table is in soft-delete mode. Like in Query, the
+// subqueries in fr are scoped too.
+func (dc *Dialect) Count(table string, fr rdb.Filter) (int64, error) {
+
+	fr = dc.softDeleteScopeFilter(fr)
+
+	col := dc.softDeleteColumn(table)
+	if col == "" {
+		return dc.Base.Count(table, fr)
+	}
+
+	where, params := softDeleteFilter(fr, col).Parse()
+
+	rs, err := dc.queryRawStrict("SELECT COUNT(*) AS num FROM "+dialectQuoteStr(table)+" WHERE "+where, params...)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(rs) == 0 {
+		return 0, errors.New("Empty Count Result")
+	}
+
+	v, ok := rs[0].Fields["num"]
+	if !ok {
+		return 0, errors.New("Empty Count Result")
+	}
+
+	return strconv.ParseInt(v.String(), 10, 64)
+}
+
+// CountIncludeDeleted is Count including the rows marked as deleted.
+func (dc *Dialect) CountIncludeDeleted(table string, fr rdb.Filter) (int64, error) {
+	return dc.Base.Count(table, fr)
+}
+
+// softDeleteFilter returns fr and the NULL check of the column.
+func softDeleteFilter(fr rdb.Filter, col string) *Filter {
+	sfr := filterNest(fr)
+	sfr.And(col+filterExprSep+"null", true)
+	return sfr
+}
+
+// softDeleteScope returns a copy of q with the NULL check of the
+// soft-delete column of the first table in From, which is also added to
+// the CTEs, the UNION, INTERSECT and EXCEPT parts and the subqueries in the
+// filter of q. Tables joined in From are not scoped.
+func (dc *Dialect) softDeleteScope(q rdb.Queryer) rdb.Queryer {
+
+	qr, ok := q.(*Queryer)
+	if !ok || qr.deleted || dc == nil {
+		return q
+	}
+
+	qc := *qr
+
+	if len(qr.ctes) > 0 {
+		qc.ctes = make([]queryCTE, len(qr.ctes))
+		for i, cte := range qr.ctes {
+			cte.query = dc.softDeleteScope(cte.query)
+			if cte.recursive != nil {
+				cte.recursive = dc.softDeleteScope(cte.recursive)
+			}
+			qc.ctes[i] = cte
+		}
+	}
+
+	if len(qr.sets) > 0 {
+		qc.sets = make([]querySet, len(qr.sets))
+		for i, set := range qr.sets {
+			set.query = dc.softDeleteScope(set.query)
+			qc.sets[i] = set
+		}
+	}
+
+	qc.where = dc.softDeleteScopeFilter(qr.where)
+
+	table, alias := queryTableName(qr.table)
+	if col := dc.softDeleteColumn(table); col != "" {
+		if alias == "" {
+			alias = table
+		}
+		qc.where = softDeleteFilter(qc.where, alias+filterExprSep+col)
+	}
+
+	return &qc
+}
+
+// softDeleteScopeFilter returns a copy of fr with its subqueries scoped by
+// softDeleteScope.
+func (dc *Dialect) softDeleteScopeFilter(fr rdb.Filter) rdb.Filter {
+
+	f, ok := fr.(*Filter)
+	if !ok || f == nil {
+		return fr
+	}
+
+	fc := &Filter{
+		params: make([]filterItem, len(f.params)),
+	}
+
+	for i, p := range f.params {
+		if p.isFilter {
+			p.filter = dc.softDeleteScopeFilter(p.filter)
+		} else {
+			args := make([]interface{}, len(p.args))
+			for j, arg := range p.args {
+				if sq, ok := arg.(rdb.Queryer); ok {
+					arg = dc.softDeleteScope(sq)
+				}
+				args[j] = arg
+			}
+			p.args = args
+		}
+		fc.params[i] = p
+	}
+
+	return fc
+}
+
+// queryTableName returns the first table and its alias of a FROM clause,
+// e.g. "users" and "u" of `"users" AS u JOIN orders o ON ...`, or
+// "public.users" of `"public"."users"`.
+func queryTableName(from string) (table, alias string) {
+
+	fs := strings.Fields(strings.Replace(from, ",", " , ", -1))
+	if len(fs) == 0 {
+		return "", ""
+	}
+
+	table = strings.Replace(fs[0], dialectQuote, "", -1)
+
+	if len(fs) > 2 && strings.ToUpper(fs[1]) == "AS" {
+		alias = fs[2]
+	} else if len(fs) > 1 && !queryFromKeywords[strings.ToUpper(fs[1])] {
+		alias = fs[1]
+	}
+
+	return table, strings.Trim(alias, dialectQuote)
+}
+
+var queryFromKeywords = map[string]bool{
+	",":       true,
+	"JOIN":    true,
+	"INNER":   true,
+	"LEFT":    true,
+	"RIGHT":   true,
+	"FULL":    true,
+	"CROSS":   true,
+	"NATURAL": true,
+}
@@ -0,0 +1,272 @@
+// Copyright 2018 Eryx <evorui аt gmail dοt com>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgsqlgo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lynkdb/iomix/rdb"
+)
+
+// Stmt is a rendered statement, which is run by one of its methods,
+// e.g. an INSERT ... RETURNING from Dialect.InsertReturning.
+type Stmt struct {
+	dc     *Dialect
+	sql    string
+	params []interface{}
+	err    error
+}
+
+func (s *Stmt) Parse() (sql string, params []interface{}, err error) {
+	return s.sql, s.params, s.err
+}
+
+func (s *Stmt) Exec() (sql.Result, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	query, params, err := dialectStmtBind(s.sql, s.params, true)
+	if err != nil {
+		return nil, err
+	}
+	return s.dc.exec(query, params)
+}
+
+// Entries runs the statement and returns the rows it returns.
+func (s *Stmt) Entries() ([]*rdb.Entry, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.dc.queryRawStrict(s.sql, s.params...)
+}
+
+// Rows runs the statement and streams the rows it returns.
+func (s *Stmt) Rows() (*Rows, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.dc.IterRaw(s.sql, s.params...)
+}
+
+// Returning adds a RETURNING clause with the given columns.
+func (s *Stmt) Returning(cols ...string) *Stmt {
+	if s.err == nil && len(cols) > 0 {
+		s.sql += " " + returningParse(cols)
+	}
+	return s
+}
+
+// Scan runs the statement and scans the rows it returns into dst, a
+// pointer to a slice of structs or of struct pointers.
+func (s *Stmt) Scan(dst interface{}) error {
+	rows, err := s.Rows()
+	if err != nil {
+		return err
+	}
+	return rows.ScanAll(dst)
+}
+
+// InsertReturning inserts item into table and returns the given columns of
+// the new row, e.g. the id set by the seq_<table>__<col> sequence.
+func (dc *Dialect) InsertReturning(table string, item map[string]interface{}, returning ...string) *Stmt {
+	query, params, err := insertParse(table, insertColumns(item), []map[string]interface{}{item})
+	return dc.stmtReturning(query, params, err, returning)
+}
+
+// UpdateReturning updates the rows of table matched by fr and returns the
+// given columns of the updated rows.
+func (dc *Dialect) UpdateReturning(table string, item map[string]interface{}, fr rdb.Filter, returning ...string) *Stmt {
+	query, params, err := updateParse(table, item, fr)
+	return dc.stmtReturning(query, params, err, returning)
+}
+
+// DeleteReturning deletes the rows of table matched by fr and returns the
+// given columns of the deleted rows. Like Delete, it only marks the rows as
+// deleted if the table is in soft-delete mode.
+func (dc *Dialect) DeleteReturning(table string, fr rdb.Filter, returning ...string) *Stmt {
+	if col := dc.softDeleteColumn(table); col != "" {
+		query, params, err := updateParse(table, map[string]interface{}{
+			col: Now(),
+		}, softDeleteFilter(fr, col))
+		return dc.stmtReturning(query, params, err, returning)
+	}
+	query, params := deleteParse(table, fr)
+	return dc.stmtReturning(query, params, nil, returning)
+}
+
+// UpdateFrom updates the rows of table joined with source, a table name or
+// a Queryer, by the filter fr: UPDATE table SET ... FROM source AS alias
+// WHERE fr. Qualified columns can be used in fr and as values, e.g.
+//
+//	dc.UpdateFrom("users", map[string]interface{}{"score": Col("s.score")},
+//		"staging", "s", NewFilter().And("users.id", Col("s.user_id")))
+func (dc *Dialect) UpdateFrom(table string, item map[string]interface{}, source interface{}, alias string, fr rdb.Filter) *Stmt {
+
+	if sq, ok := source.(rdb.Queryer); ok {
+		source = dc.softDeleteScope(sq)
+	}
+
+	from, fromPs, err := joinParse(source, alias)
+	if err != nil {
+		return dc.stmtReturning("", nil, err, nil)
+	}
+
+	if w, _ := filterParse(fr); w == "" {
+		return dc.stmtReturning("", nil, errors.New("UpdateFrom requires a join filter"), nil)
+	}
+
+	query, params, err := updateFromParse(table, item, from, fromPs, fr)
+	return dc.stmtReturning(query, params, err, nil)
+}
+
+// DeleteUsing deletes the rows of table joined with source, a table name or
+// a Queryer, by the filter fr: DELETE FROM table USING source AS alias
+// WHERE fr. Like Delete, it only marks the rows as deleted if the table is
+// in soft-delete mode, through UPDATE table SET ... FROM source.
+func (dc *Dialect) DeleteUsing(table string, source interface{}, alias string, fr rdb.Filter) *Stmt {
+
+	if sq, ok := source.(rdb.Queryer); ok {
+		source = dc.softDeleteScope(sq)
+	}
+
+	using, usingPs, err := joinParse(source, alias)
+	if err != nil {
+		return dc.stmtReturning("", nil, err, nil)
+	}
+
+	w, ps := filterParse(fr)
+	if w == "" {
+		return dc.stmtReturning("", nil, errors.New("DeleteUsing requires a join filter"), nil)
+	}
+
+	if col := dc.softDeleteColumn(table); col != "" {
+		query, params, err := updateFromParse(table, map[string]interface{}{
+			col: Now(),
+		}, using, usingPs, softDeleteFilter(fr, table+filterExprSep+col))
+		return dc.stmtReturning(query, params, err, nil)
+	}
+
+	query := "DELETE FROM " + dialectQuoteStr(table) + " USING " + using
+
+	return dc.stmtReturning(query+" WHERE "+w, append(usingPs, ps...), nil, nil)
+}
+
+func joinParse(source interface{}, alias string) (string, []interface{}, error) {
+
+	switch v := source.(type) {
+
+	case string:
+		if v == "" {
+			break
+		}
+		sql := dialectQuoteStr(v)
+		if alias != "" {
+			sql += " AS " + dialectQuoteStr(alias)
+		}
+		return sql, nil, nil
+
+	case rdb.Queryer:
+		if alias == "" {
+			return "", nil, errors.New("A subquery source requires an alias")
+		}
+		sql, params := querySubParse(v)
+		return "(" + sql + ") AS " + dialectQuoteStr(alias), params, nil
+	}
+
+	return "", nil, errors.New("Invalid join source, must be a table name or a Queryer")
+}
+
+func (dc *Dialect) stmtReturning(query string, params []interface{}, err error, returning []string) *Stmt {
+	if err == nil && len(returning) > 0 {
+		query += " " + returningParse(returning)
+	}
+	return &Stmt{
+		dc:     dc,
+		sql:    query,
+		params: params,
+		err:    err,
+	}
+}
+
+func returningParse(cols []string) string {
+	names := make([]string, len(cols))
+	for i, v := range cols {
+		names[i] = dialectQuoteStr(strings.TrimSpace(v))
+	}
+	return "RETURNING " + strings.Join(names, ",")
+}
+
+// updateParse renders an UPDATE statement, an Expr value is written as is,
+// any other value is bound as "?".
+func updateParse(table string, item map[string]interface{}, fr rdb.Filter) (string, []interface{}, error) {
+	return updateFromParse(table, item, "", nil, fr)
+}
+
+func updateFromParse(table string, item map[string]interface{},
+	from string, fromPs []interface{}, fr rdb.Filter) (string, []interface{}, error) {
+
+	cols := insertColumns(item)
+	if len(cols) == 0 {
+		return "", nil, errors.New("No columns to update")
+	}
+
+	var (
+		sets   = make([]string, len(cols))
+		params []interface{}
+	)
+
+	for i, col := range cols {
+		w, ps := filterArgParse(item[col])
+		sets[i] = fmt.Sprintf("%s = %s", dialectQuoteStr(col), w)
+		params = append(params, ps...)
+	}
+
+	query := fmt.Sprintf("UPDATE %s SET %s", dialectQuoteStr(table), strings.Join(sets, ","))
+
+	if from != "" {
+		query += " FROM " + from
+		params = append(params, fromPs...)
+	}
+
+	if w, ps := filterParse(fr); w != "" {
+		query += " WHERE " + w
+		params = append(params, ps...)
+	}
+
+	return query, params, nil
+}
+
+func deleteParse(table string, fr rdb.Filter) (string, []interface{}) {
+
+	query := "DELETE FROM " + dialectQuoteStr(table)
+
+	w, params := filterParse(fr)
+	if w != "" {
+		query += " WHERE " + w
+	}
+
+	return query, params
+}
+
+func filterParse(fr rdb.Filter) (string, []interface{}) {
+	if fr == nil {
+		return "", nil
+	}
+	w, ps := fr.Parse()
+	return strings.TrimSpace(w), ps
+}
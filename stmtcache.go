@@ -0,0 +1,248 @@
+// Copyright 2018 Eryx <evorui аt gmail dοt com>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgsqlgo
+
+import (
+	"container/list"
+	"database/sql"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+	"github.com/lynkdb/iomix/rdb"
+)
+
+const (
+	stmtCacheSizeDefault = 128
+)
+
+// stmtCache is a LRU cache of prepared statements keyed by the rewritten
+// SQL. A *sql.Stmt is prepared on each connection of the pool on first use
+// there, so the server parses and plans the statement once per connection.
+//
+// Only statements with args are cached, they are sent by the extended
+// protocol anyway, which also keeps multi-command DDL statements out. The
+// cache is set up by the "stmt_cache_size" option of the connector, "0"
+// turns it off, e.g. behind PgBouncer in transaction pooling mode.
+type stmtCache struct {
+	mu    sync.Mutex
+	db    *sql.DB
+	size  int
+	lru   *list.List
+	items map[string]*list.Element
+}
+
+type stmtCacheItem struct {
+	query   string
+	stmt    *sql.Stmt
+	refs    int  // users between get and release
+	removed bool // closed on the last release
+}
+
+func newStmtCache(db *sql.DB, size int) *stmtCache {
+	return &stmtCache{
+		db:    db,
+		size:  size,
+		lru:   list.New(),
+		items: map[string]*list.Element{},
+	}
+}
+
+// get returns the statement of query, which is prepared outside of the
+// lock on a miss. The caller must release it once the statement has been
+// run, an evicted statement is only closed then.
+func (c *stmtCache) get(query string) (*stmtCacheItem, error) {
+
+	if item := c.acquire(query); item != nil {
+		return item, nil
+	}
+
+	stmt, err := c.db.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// prepared by another goroutine meanwhile
+	if el, ok := c.items[query]; ok {
+		stmt.Close()
+		c.lru.MoveToFront(el)
+		item := el.Value.(*stmtCacheItem)
+		item.refs++
+		return item, nil
+	}
+
+	item := &stmtCacheItem{
+		query: query,
+		stmt:  stmt,
+		refs:  1,
+	}
+	c.items[query] = c.lru.PushFront(item)
+
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+
+	return item, nil
+}
+
+func (c *stmtCache) acquire(query string) *stmtCacheItem {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[query]; ok {
+		c.lru.MoveToFront(el)
+		item := el.Value.(*stmtCacheItem)
+		item.refs++
+		return item
+	}
+	return nil
+}
+
+// release ends the use of item. Rows which are still open keep the
+// statement alive in database/sql, even if it is closed here.
+func (c *stmtCache) release(item *stmtCacheItem) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if item.refs--; item.refs == 0 && item.removed {
+		item.stmt.Close()
+	}
+}
+
+// del removes the statement, e.g. after a schema change made its cached
+// plan invalid.
+func (c *stmtCache) del(query string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[query]; ok {
+		c.remove(el)
+	}
+}
+
+func (c *stmtCache) remove(el *list.Element) {
+	item := c.lru.Remove(el).(*stmtCacheItem)
+	delete(c.items, item.query)
+	item.removed = true
+	if item.refs == 0 {
+		item.stmt.Close()
+	}
+}
+
+func (c *stmtCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+// stmtCachePlanChanged reports whether err is raised by a prepared
+// statement whose result type was changed by a schema change.
+func stmtCachePlanChanged(err error) bool {
+	pe, ok := err.(*pq.Error)
+	return ok && pe.Code == "0A000" && strings.Contains(pe.Message, "cached plan must not change result type")
+}
+
+// ExecRaw is the ExecRaw of rdb.Base through the statement cache, unlike
+// BindVar it reports a placeholder mismatch.
+func (dc *Dialect) ExecRaw(query string, args ...interface{}) (sql.Result, error) {
+	query, args, err := dialectStmtBind(query, args, dc.bindStrict)
+	if err != nil {
+		return nil, err
+	}
+	return dc.exec(query, args)
+}
+
+// QueryRaw is the QueryRaw of rdb.Base through the statement cache, unlike
+// BindVar it reports a placeholder mismatch.
+func (dc *Dialect) QueryRaw(query string, args ...interface{}) ([]*rdb.Entry, error) {
+	query, args, err := dialectStmtBind(query, args, dc.bindStrict)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := dc.query(query, args)
+	if err != nil {
+		return nil, err
+	}
+	return (&Rows{rows}).entries(dc.TypeDatetimeFmt)
+}
+
+// exec runs a statement which is already numbered, with the statement
+// cache if possible. A statement invalidated by a schema change is
+// prepared again once.
+func (dc *Dialect) exec(query string, args []interface{}) (sql.Result, error) {
+
+	if dc.stmts == nil || len(args) == 0 {
+		return dc.DB().Exec(query, args...)
+	}
+
+	for retry := 0; ; retry++ {
+
+		item, err := dc.stmts.get(query)
+		if err != nil {
+			return nil, err
+		}
+
+		rs, err := item.stmt.Exec(args...)
+		dc.stmts.release(item)
+		if err != nil && retry == 0 && stmtCachePlanChanged(err) {
+			dc.stmts.del(query)
+			continue
+		}
+
+		return rs, err
+	}
+}
+
+// query is like exec for statements which return rows.
+func (dc *Dialect) query(query string, args []interface{}) (*sql.Rows, error) {
+
+	if dc.stmts == nil || len(args) == 0 {
+		return dc.DB().Query(query, args...)
+	}
+
+	for retry := 0; ; retry++ {
+
+		item, err := dc.stmts.get(query)
+		if err != nil {
+			return nil, err
+		}
+
+		rows, err := item.stmt.Query(args...)
+		dc.stmts.release(item)
+		if err != nil && retry == 0 && stmtCachePlanChanged(err) {
+			dc.stmts.del(query)
+			continue
+		}
+
+		return rows, err
+	}
+}
+
+// txStmt returns the cached statement of query for use in tx, or nil. A
+// statement invalidated by a schema change can not be retried in the
+// aborted transaction, it is only removed from the cache.
+func (tx *Tx) txStmt(query string, args []interface{}) (*sql.Stmt, error) {
+	if tx.dc == nil || tx.dc.stmts == nil || len(args) == 0 {
+		return nil, nil
+	}
+	item, err := tx.dc.stmts.get(query)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.dc.stmts.release(item)
+	return tx.tx.Stmt(item.stmt), nil
+}
@@ -0,0 +1,325 @@
+// Copyright 2018 Eryx <evorui аt gmail dοt com>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgsqlgo
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/lynkdb/iomix/rdb"
+)
+
+const (
+	structTagName = "db"
+)
+
+// structField is the position of a column in a struct, json fields
+// (tagged `db:"name,json"`) are decoded from the column value.
+type structField struct {
+	index  []int
+	json   bool
+	tagged bool
+}
+
+var structPlans sync.Map // reflect.Type -> map[string]*structField
+
+// structPlan returns the column to field mapping of the struct type t, it
+// is built once per type. The column name is taken from the `db` tag, or
+// the lower cased field name, fields tagged `db:"-"` are skipped and the
+// fields of embedded structs are promoted. Like in encoding/json, the least
+// nested field of a name wins, then the tagged one, and a name which is
+// still ambiguous is dropped.
+func structPlan(t reflect.Type) map[string]*structField {
+
+	if v, ok := structPlans.Load(t); ok {
+		return v.(map[string]*structField)
+	}
+
+	fields := map[string][]*structField{}
+	structPlanFields(t, nil, map[reflect.Type]bool{t: true}, fields)
+
+	plan := map[string]*structField{}
+	for name, fs := range fields {
+		if sf := structPlanDominant(fs); sf != nil {
+			plan[name] = sf
+		}
+	}
+
+	v, _ := structPlans.LoadOrStore(t, plan)
+	return v.(map[string]*structField)
+}
+
+// structPlanFields collects the fields of t by column name, visited holds
+// the embedded types on the path, so that a self embedding type ends.
+func structPlanFields(t reflect.Type, index []int, visited map[reflect.Type]bool, fields map[string][]*structField) {
+
+	for i := 0; i < t.NumField(); i++ {
+
+		var (
+			field = t.Field(i)
+			tag   = field.Tag.Get(structTagName)
+			opts  = strings.Split(tag, ",")
+			name  = opts[0]
+			fi    = append(append([]int{}, index...), i)
+		)
+
+		if name == "-" || (field.PkgPath != "" && !field.Anonymous) {
+			continue
+		}
+
+		if field.Anonymous && name == "" {
+			ft := field.Type
+			if ft.Kind() == reflect.Ptr {
+				if field.PkgPath != "" {
+					continue
+				}
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if !visited[ft] {
+					visited[ft] = true
+					structPlanFields(ft, fi, visited, fields)
+					delete(visited, ft)
+				}
+				continue
+			}
+		}
+
+		if field.PkgPath != "" {
+			continue
+		}
+
+		sf := &structField{
+			index:  fi,
+			tagged: name != "",
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		for _, opt := range opts[1:] {
+			if opt == "json" {
+				sf.json = true
+			}
+		}
+		fields[name] = append(fields[name], sf)
+	}
+}
+
+func structPlanDominant(fs []*structField) *structField {
+
+	var (
+		depth = -1
+		top   []*structField
+	)
+
+	for _, sf := range fs {
+		if depth < 0 || len(sf.index) < depth {
+			depth, top = len(sf.index), nil
+		}
+		if len(sf.index) == depth {
+			top = append(top, sf)
+		}
+	}
+
+	if len(top) == 1 {
+		return top[0]
+	}
+
+	var tagged *structField
+	for _, sf := range top {
+		if sf.tagged {
+			if tagged != nil {
+				return nil
+			}
+			tagged = sf
+		}
+	}
+
+	return tagged
+}
+
+// structFieldValue returns the field at index, allocating nil embedded
+// struct pointers on the way.
+func structFieldValue(v reflect.Value, index []int) reflect.Value {
+	for i, n := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(n)
+	}
+	return v
+}
+
+// structFieldRead returns the field at index, it is not ok if an embedded
+// struct pointer on the way is nil.
+func structFieldRead(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, n := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(n)
+	}
+	return v, true
+}
+
+type structScanner interface {
+	Columns() ([]string, error)
+	Scan(dest ...interface{}) error
+}
+
+func structScan(rows structScanner, dst interface{}) error {
+
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("Invalid destination, must be a pointer to a struct")
+	}
+	rv = rv.Elem()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	var (
+		plan = structPlan(rv.Type())
+		dest = make([]interface{}, len(cols))
+	)
+
+	for i, col := range cols {
+		sf, ok := plan[col]
+		if !ok {
+			dest[i] = new(interface{})
+			continue
+		}
+		if sf.json {
+			dest[i] = new([]byte)
+			continue
+		}
+		dest[i] = structFieldValue(rv, sf.index).Addr().Interface()
+	}
+
+	if err := rows.Scan(dest...); err != nil {
+		return err
+	}
+
+	for i, col := range cols {
+		sf, ok := plan[col]
+		if !ok || !sf.json {
+			continue
+		}
+		if bs := *(dest[i].(*[]byte)); len(bs) > 0 {
+			fv := structFieldValue(rv, sf.index)
+			if err := json.Unmarshal(bs, fv.Addr().Interface()); err != nil {
+				return errors.New("Invalid json value of column " + col + ": " + err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+// ScanStruct copies the columns of the current row into the fields of the
+// struct pointed to by dst, see structPlan for the mapping rules.
+func (r *Rows) ScanStruct(dst interface{}) error {
+	return structScan(r.Rows, dst)
+}
+
+// ScanStruct is like Rows.ScanStruct for the current row of the cursor.
+func (cur *Cursor) ScanStruct(dst interface{}) error {
+	if cur.rows == nil {
+		return errors.New("Scan called without calling Next")
+	}
+	return structScan(cur.rows, dst)
+}
+
+// ScanAll reads all remaining rows into dst, which must be a pointer to a
+// slice of structs or of struct pointers, and closes the rows.
+func (r *Rows) ScanAll(dst interface{}) error {
+
+	defer r.Close()
+
+	sv := reflect.ValueOf(dst)
+	if sv.Kind() != reflect.Ptr || sv.IsNil() || sv.Elem().Kind() != reflect.Slice {
+		return errors.New("Invalid destination, must be a pointer to a slice")
+	}
+	sv = sv.Elem()
+
+	var (
+		et    = sv.Type().Elem()
+		isPtr = et.Kind() == reflect.Ptr
+	)
+	if isPtr {
+		et = et.Elem()
+	}
+	if et.Kind() != reflect.Struct {
+		return errors.New("Invalid destination, must be a pointer to a slice of structs")
+	}
+
+	for r.Next() {
+		ev := reflect.New(et)
+		if err := r.ScanStruct(ev.Interface()); err != nil {
+			return err
+		}
+		if isPtr {
+			sv.Set(reflect.Append(sv, ev))
+		} else {
+			sv.Set(reflect.Append(sv, ev.Elem()))
+		}
+	}
+
+	return r.Err()
+}
+
+// QueryStruct runs the query and scans all rows into dst, a pointer to a
+// slice of structs or of struct pointers.
+func (dc *Dialect) QueryStruct(q rdb.Queryer, dst interface{}) error {
+	rows, err := dc.Iter(q)
+	if err != nil {
+		return err
+	}
+	return rows.ScanAll(dst)
+}
+
+// FetchStruct scans the first row of the query into the struct pointed
+// to by dst, it returns sql.ErrNoRows if there is no result.
+func (dc *Dialect) FetchStruct(q rdb.Queryer, dst interface{}) error {
+
+	q.Limit(1)
+
+	rows, err := dc.Iter(q)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+
+	return rows.ScanStruct(dst)
+}
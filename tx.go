@@ -0,0 +1,134 @@
+// Copyright 2018 Eryx <evorui аt gmail dοt com>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgsqlgo
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/lynkdb/iomix/rdb"
+)
+
+var (
+	ErrLockOutsideTx = errors.New("Row locking clauses require an active transaction")
+)
+
+type Tx struct {
+	dc *Dialect
+	tx *sql.Tx
+}
+
+// Begin starts a transaction, the caller must finish it with Commit or
+// Rollback.
+func (dc *Dialect) Begin() (*Tx, error) {
+	tx, err := dc.DB().Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{
+		dc: dc,
+		tx: tx,
+	}, nil
+}
+
+func (tx *Tx) Tx() *sql.Tx {
+	return tx.tx
+}
+
+func (tx *Tx) Commit() error {
+	return tx.tx.Commit()
+}
+
+func (tx *Tx) Rollback() error {
+	return tx.tx.Rollback()
+}
+
+func (tx *Tx) ExecRaw(query string, args ...interface{}) (sql.Result, error) {
+	query, args, err := dialectStmtBind(query, args, true)
+	if err != nil {
+		return nil, err
+	}
+	return tx.exec(query, args)
+}
+
+// execUncached is ExecRaw without the statement cache, for one-off
+// statements like the chunks of InsertBatch, which would evict the
+// statements that are run often.
+func (tx *Tx) execUncached(query string, args []interface{}) (sql.Result, error) {
+	query, args, err := dialectStmtBind(query, args, true)
+	if err != nil {
+		return nil, err
+	}
+	return tx.tx.Exec(query, args...)
+}
+
+func (tx *Tx) exec(query string, args []interface{}) (sql.Result, error) {
+	stmt, err := tx.txStmt(query, args)
+	if err != nil {
+		return nil, err
+	} else if stmt != nil {
+		rs, err := stmt.Exec(args...)
+		if stmtCachePlanChanged(err) {
+			tx.dc.stmts.del(query)
+		}
+		return rs, err
+	}
+	return tx.tx.Exec(query, args...)
+}
+
+func (tx *Tx) QueryRaw(query string, args ...interface{}) (*Rows, error) {
+	query, args, err := dialectStmtBind(query, args, true)
+	if err != nil {
+		return nil, err
+	}
+	return tx.query(query, args)
+}
+
+func (tx *Tx) query(query string, args []interface{}) (*Rows, error) {
+	var rows *sql.Rows
+	stmt, err := tx.txStmt(query, args)
+	if err != nil {
+		return nil, err
+	} else if stmt != nil {
+		if rows, err = stmt.Query(args...); stmtCachePlanChanged(err) {
+			tx.dc.stmts.del(query)
+		}
+	} else {
+		rows, err = tx.tx.Query(query, args...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Rows{rows}, nil
+}
+
+func (tx *Tx) Query(q rdb.Queryer) (*Rows, error) {
+	if err := queryCheck(q, true); err != nil {
+		return nil, err
+	}
+	query, args := tx.dc.softDeleteScope(q).Parse()
+	return tx.QueryRaw(query, args...)
+}
+
+func queryCheck(q rdb.Queryer, inTx bool) error {
+	qr, ok := q.(*Queryer)
+	if !ok {
+		return nil
+	}
+	if qr.Locked() && !inTx {
+		return ErrLockOutsideTx
+	}
+	return qr.Valid()
+}
@@ -0,0 +1,238 @@
+// Copyright 2018 Eryx <evorui аt gmail dοt com>, All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pgsqlgo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lynkdb/iomix/rdb"
+)
+
+var (
+	ErrStaleVersion = errors.New("Stale version, the row has been changed or removed")
+)
+
+// UpdateBatch updates many rows of table with different values in one
+// transaction. Each item holds the key column and the columns to set, all
+// items must have the same columns. The rows are updated by one statement
+// per chunk, the number of rows updated by each chunk is returned:
+//
+//	UPDATE t SET a = v.a, ... FROM (VALUES (...), ...) AS v (key, a, ...)
+//	WHERE t.key = v.key
+func (dc *Dialect) UpdateBatch(table, key string, items []map[string]interface{}) ([]int64, error) {
+
+	tx, err := dc.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	rs, err := tx.UpdateBatch(table, key, items)
+	if err != nil {
+		tx.Rollback()
+		return rs, err
+	}
+
+	return rs, tx.Commit()
+}
+
+// UpdateBatch is like Dialect.UpdateBatch, inside the transaction.
+func (tx *Tx) UpdateBatch(table, key string, items []map[string]interface{}) ([]int64, error) {
+
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	cols := insertColumns(items...)
+	for i, item := range items {
+		if _, ok := item[key]; !ok {
+			return nil, fmt.Errorf("Update row %d: missing key column %s", i, key)
+		}
+		if len(item) != len(cols) {
+			return nil, fmt.Errorf("Update row %d: all rows must have the same columns", i)
+		}
+	}
+	if len(cols) < 2 {
+		return nil, errors.New("No columns to update")
+	}
+
+	// the VALUES list is cast to the types of the table columns, untyped
+	// parameters would be taken as text otherwise
+	types, err := tx.columnTypes(table)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		names = make([]string, len(cols))
+		sets  = []string{}
+	)
+	for i, col := range cols {
+		if types[col] == "" {
+			return nil, fmt.Errorf("Unknown column %s of table %s", col, table)
+		}
+		names[i] = dialectQuoteStr(col)
+		if col != key {
+			sets = append(sets, fmt.Sprintf("%s = %s", dialectQuoteStr(col), dialectQuoteStr("v."+col)))
+		}
+	}
+
+	tail := fmt.Sprintf(") AS %s (%s) WHERE %s = %s",
+		dialectQuoteStr("v"), strings.Join(names, ","),
+		dialectQuoteStr(table+"."+key), dialectQuoteStr("v."+key))
+	head := fmt.Sprintf("UPDATE %s SET %s FROM (VALUES ",
+		dialectQuoteStr(table), strings.Join(sets, ","))
+
+	rs := []int64{}
+
+	for offset := 0; offset < len(items); {
+
+		var (
+			num    = 0
+			params = 0
+		)
+		for _, item := range items[offset:] {
+			n := insertParamsNum(item)
+			if num > 0 && params+n > insertBatchParamsMax {
+				break
+			}
+			num++
+			params += n
+		}
+
+		var (
+			rows = make([]string, num)
+			ps   []interface{}
+		)
+		for i, item := range items[offset : offset+num] {
+			vals := make([]string, len(cols))
+			for j, col := range cols {
+				w, vps := filterArgParse(item[col])
+				vals[j] = fmt.Sprintf("(%s)::%s", w, types[col])
+				ps = append(ps, vps...)
+			}
+			rows[i] = "(" + strings.Join(vals, ",") + ")"
+		}
+
+		res, err := tx.execUncached(head+strings.Join(rows, ",")+tail, ps)
+		if err != nil {
+			return rs, fmt.Errorf("Update rows %d to %d: %s", offset, offset+num-1, err.Error())
+		}
+
+		n, _ := res.RowsAffected()
+		rs = append(rs, n)
+
+		offset += num
+	}
+
+	return rs, nil
+}
+
+// columnTypes returns the SQL types of the columns of table, e.g.
+// "character varying(50)" or "bigint".
+func (tx *Tx) columnTypes(table string) (map[string]string, error) {
+
+	rows, err := tx.QueryRaw("SELECT attname, format_type(atttypid, atttypmod) "+
+		"FROM pg_attribute WHERE attrelid = ?::regclass AND attnum > 0 AND NOT attisdropped",
+		dialectQuoteStr(table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types := map[string]string{}
+	for rows.Next() {
+		var name, typ string
+		if err = rows.Scan(&name, &typ); err != nil {
+			return nil, err
+		}
+		types[name] = typ
+	}
+
+	return types, rows.Err()
+}
+
+// UpdateVersion updates the row matched by fr only if its integer version
+// column still holds version, and increments the column:
+//
+//	UPDATE t SET ..., version = version + 1 WHERE fr AND version = ?
+//
+// It returns ErrStaleVersion if no row matches, i.e. the row has been
+// updated by someone else since version was read.
+func (dc *Dialect) UpdateVersion(table string, item map[string]interface{},
+	fr rdb.Filter, column string, version int64) (sql.Result, error) {
+
+	query, params, err := updateVersionParse(table, item, fr, column, version)
+	if err != nil {
+		return nil, err
+	}
+
+	query, params, err = dialectStmtBind(query, params, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return updateVersionResult(dc.exec(query, params))
+}
+
+// UpdateVersion is like Dialect.UpdateVersion, inside the transaction.
+func (tx *Tx) UpdateVersion(table string, item map[string]interface{},
+	fr rdb.Filter, column string, version int64) (sql.Result, error) {
+
+	query, params, err := updateVersionParse(table, item, fr, column, version)
+	if err != nil {
+		return nil, err
+	}
+
+	return updateVersionResult(tx.ExecRaw(query, params...))
+}
+
+func updateVersionParse(table string, item map[string]interface{},
+	fr rdb.Filter, column string, version int64) (string, []interface{}, error) {
+
+	if column == "" {
+		return "", nil, errors.New("No version column")
+	}
+
+	if _, ok := item[column]; ok {
+		return "", nil, errors.New("The version column " + column + " is set by UpdateVersion")
+	}
+
+	sets := map[string]interface{}{
+		column: Incr(column, 1),
+	}
+	for k, v := range item {
+		sets[k] = v
+	}
+
+	vfr := filterNest(fr)
+	vfr.And(column, version)
+
+	return updateParse(table, sets, vfr)
+}
+
+func updateVersionResult(rs sql.Result, err error) (sql.Result, error) {
+	if err != nil {
+		return nil, err
+	}
+	if n, err := rs.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrStaleVersion
+	}
+	return rs, nil
+}